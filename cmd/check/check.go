@@ -0,0 +1,241 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"dct/cmd/utils"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	PASS = "PASS"
+	FAIL = "FAIL"
+)
+
+var (
+	defaultWriter = os.Stdout
+	output        string
+	writer        io.Writer
+	samples       int
+)
+
+func init() {
+	CheckCmd.Flags().StringVarP(&output, "output", "o", "", "Output report to file")
+	CheckCmd.Flags().IntVarP(&samples, "samples", "n", 5, "Number of failing rows to show per check")
+}
+
+var CheckCmd = &cobra.Command{
+	Use:   "check <rules> <source>",
+	Short: "Check data against rules",
+	Long: `Check a data file or database table against the expectations in a yaml rules file:
+
+	row_count: {min: 1, max: 1000000}
+	columns:
+	  - name: id
+	    not_null: true
+	    unique: true
+	  - name: status
+	    in: [active, inactive]
+	  - name: email
+	    regex: '^[^@]+@[^@]+$'
+	  - name: amount
+	    min: 0
+	    max: 10000
+	  - name: customer_id
+	    references: {source: customers.csv, column: id}
+
+	Exits with a non-zero code when any check fails`,
+	Args: cobra.MatchAll(cobra.ExactArgs(2), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		rules, source := parseArgs(args)
+
+		var err error
+		writer = defaultWriter
+		if output != "" {
+			writer, err = os.Create(output)
+			if err != nil {
+				log.Printf("Warning: failed to create out file defaulting to %v\n", defaultWriter)
+			}
+		}
+
+		if samples < 1 {
+			log.Printf("Warning: expected -n to be at least 1 defaulting to 5\n")
+			samples = 5
+		}
+
+		results := check(rules, source)
+		report(results, writer)
+
+		failed := 0
+		for _, r := range results {
+			if r.Status == FAIL {
+				failed++
+			}
+		}
+		if failed > 0 {
+			log.Printf("%d of %d checks failed\n", failed, len(results))
+			os.Exit(1)
+		}
+	},
+}
+
+func parseArgs(args []string) (Rules, utils.Source) {
+	ext := strings.ToLower(path.Ext(args[0]))
+	if !slices.Contains(utils.CHECK_RULES_FILETYPES, ext) {
+		log.Fatalf("Error: unsupported rules file type: %s\n", ext)
+	}
+
+	rules, err := parseRules(args[0])
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	source, err := utils.ParseSource(args[1], "data")
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	return rules, source
+}
+
+type Result struct {
+	Check
+	Actual string
+	Status string
+	Sample utils.Result
+}
+
+func check(rules Rules, source utils.Source) []Result {
+	var results []Result
+	if rules.RowCount != nil {
+		result, err := utils.Query(generateRowCountSQL(source))
+		if err != nil {
+			log.Fatalf("Error: failed to count rows of %s: %v\n", source, err)
+		}
+
+		count, _ := result.Rows[0][0].(int)
+		status := PASS
+		if !rules.RowCount.Contains(count) {
+			status = FAIL
+		}
+
+		results = append(results, Result{
+			Check:  Check{Rule: ROW_COUNT_RULE, Expect: rules.RowCount.String()},
+			Actual: fmt.Sprintf("%d rows", count),
+			Status: status,
+		})
+	}
+
+	for i, col := range rules.Columns {
+		checks, err := col.checks(i, source)
+		if err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+
+		for _, c := range checks {
+			sample, err := utils.Query(generateCheckSQL(c, source, samples))
+			if err != nil {
+				log.Fatalf("Error: failed to check %s of `%s`: %v\n", c.Rule, c.Column, err)
+			}
+
+			failing := 0
+			if len(sample.Rows) > 0 {
+				failing, _ = sample.Rows[0][0].(int)
+			}
+
+			// the failing row count is reported once, not per sample row
+			sample.Headers = sample.Headers[1:]
+			for j, row := range sample.Rows {
+				sample.Rows[j] = row[1:]
+			}
+
+			status := PASS
+			if failing > 0 {
+				status = FAIL
+			}
+
+			results = append(results, Result{
+				Check:  c,
+				Actual: fmt.Sprintf("%d failing rows", failing),
+				Status: status,
+				Sample: sample,
+			})
+		}
+	}
+
+	return results
+}
+
+func report(results []Result, writer io.Writer) {
+	summary := utils.Result{
+		Headers: []utils.Header{
+			{Name: "rule", Type: "VARCHAR"},
+			{Name: "column", Type: "VARCHAR"},
+			{Name: "expect", Type: "VARCHAR"},
+			{Name: "actual", Type: "VARCHAR"},
+			{Name: "status", Type: "VARCHAR"},
+		},
+	}
+	for _, r := range results {
+		summary.Rows = append(summary.Rows, []any{r.Rule, r.Column, r.Expect, r.Actual, r.Status})
+	}
+
+	if output != "" {
+		_ = summary.ToCsv(writer)
+		writeFailures(results)
+		return
+	}
+
+	_ = summary.Render(writer, len(summary.Rows))
+	for _, r := range results {
+		if r.Status == PASS || len(r.Sample.Rows) == 0 {
+			continue
+		}
+
+		_, _ = fmt.Fprintf(writer, "\n-- Failing rows: %s `%s` (%s) --\n", r.Rule, r.Column, r.Actual)
+		_ = r.Sample.Render(writer, len(r.Sample.Rows))
+	}
+}
+
+// writeFailures writes the failing rows of every check next to --output
+// e.g. out-failures.csv, each row led by the rule and column it failed
+func writeFailures(results []Result) {
+	var failures utils.Result
+	for _, r := range results {
+		if r.Status == PASS || len(r.Sample.Rows) == 0 {
+			continue
+		}
+
+		if failures.Headers == nil {
+			failures.Headers = append([]utils.Header{
+				{Name: "rule", Type: "VARCHAR"},
+				{Name: "column", Type: "VARCHAR"},
+			}, r.Sample.Headers...)
+		}
+		for _, row := range r.Sample.Rows {
+			failures.Rows = append(failures.Rows, append([]any{r.Rule, r.Column}, row...))
+		}
+	}
+	if len(failures.Rows) == 0 {
+		return
+	}
+
+	ext := path.Ext(output)
+	file := fmt.Sprintf("%s-failures%s", strings.TrimSuffix(output, ext), ext)
+	f, err := os.Create(file)
+	if err != nil {
+		log.Fatalf("Error: failed to create failures file: %v\n", err)
+	}
+	err = errors.Join(failures.ToCsv(f), f.Close())
+	if err != nil {
+		log.Fatalf("Error: failed to write failures file: %v\n", err)
+	}
+}
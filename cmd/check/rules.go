@@ -0,0 +1,253 @@
+package check
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"dct/cmd/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ROW_COUNT_RULE  = "row_count"
+	NOT_NULL_RULE   = "not_null"
+	UNIQUE_RULE     = "unique"
+	IN_RULE         = "in"
+	REGEX_RULE      = "regex"
+	RANGE_RULE      = "range"
+	REFERENCES_RULE = "references"
+)
+
+// Rules are the expectations of a data source, read from yaml e.g.
+//
+//	row_count: {min: 1}
+//	columns:
+//	  - name: id
+//	    not_null: true
+//	    unique: true
+//	  - name: customer_id
+//	    references: {source: customers.csv, column: id}
+type Rules struct {
+	RowCount *Bounds       `yaml:"row_count"`
+	Columns  []ColumnRules `yaml:"columns"`
+}
+
+type Bounds struct {
+	Min *int `yaml:"min"`
+	Max *int `yaml:"max"`
+}
+
+type ColumnRules struct {
+	Name       string     `yaml:"name"`
+	NotNull    bool       `yaml:"not_null"`
+	Unique     bool       `yaml:"unique"`
+	In         []any      `yaml:"in"`
+	Regex      string     `yaml:"regex"`
+	Min        any        `yaml:"min"`
+	Max        any        `yaml:"max"`
+	References *Reference `yaml:"references"`
+}
+
+type Reference struct {
+	Source string `yaml:"source"`
+	Column string `yaml:"column"`
+}
+
+type InvalidRulesErr struct {
+	Msg  string
+	File string
+}
+
+func (e InvalidRulesErr) Error() string {
+	return fmt.Sprintf("%s: %s", e.Msg, e.File)
+}
+
+func parseRules(file string) (Rules, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return Rules{}, err
+	}
+
+	// unknown keys are most likely misspelt rules, so they are errors
+	var rules Rules
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&rules); err != nil {
+		return Rules{}, InvalidRulesErr{fmt.Sprintf("failed to parse rules: %v", err), file}
+	}
+
+	if rules.RowCount == nil && len(rules.Columns) == 0 {
+		return Rules{}, InvalidRulesErr{"expected row_count or columns rules", file}
+	}
+	for i, col := range rules.Columns {
+		if col.Name == "" {
+			return Rules{}, InvalidRulesErr{fmt.Sprintf("missing name for column rules %d", i), file}
+		}
+		if ref := col.References; ref != nil && (ref.Source == "" || ref.Column == "") {
+			return Rules{}, InvalidRulesErr{fmt.Sprintf("expected source and column for references of `%s`", col.Name), file}
+		}
+	}
+
+	return rules, nil
+}
+
+// literal writes a yaml value as a sql literal, strings and dates are quoted
+func literal(v any) string {
+	switch v := v.(type) {
+	case string:
+		return fmt.Sprintf("'%s'", strings.ReplaceAll(v, "'", "''"))
+	case time.Time:
+		return fmt.Sprintf("'%s'", v.Format(time.RFC3339Nano))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Check is a single expectation, Predicate selects the rows failing it
+type Check struct {
+	Rule      string
+	Column    string
+	Expect    string
+	Predicate string
+	Reference *utils.Source
+}
+
+// checks lists the expectations of a column of source, i keeps reference
+// sources of different columns apart
+func (c ColumnRules) checks(i int, source utils.Source) ([]Check, error) {
+	col := utils.QuoteIdent(c.Name)
+
+	var checks []Check
+	if c.NotNull {
+		checks = append(checks, Check{
+			Rule:      NOT_NULL_RULE,
+			Column:    c.Name,
+			Expect:    "not null",
+			Predicate: fmt.Sprintf("%s is null", col),
+		})
+	}
+
+	if c.Unique {
+		checks = append(checks, Check{
+			Rule:   UNIQUE_RULE,
+			Column: c.Name,
+			Expect: "unique",
+		})
+	}
+
+	if len(c.In) > 0 {
+		var values []string
+		for _, v := range c.In {
+			values = append(values, literal(v))
+		}
+		checks = append(checks, Check{
+			Rule:      IN_RULE,
+			Column:    c.Name,
+			Expect:    fmt.Sprintf("in (%s)", strings.Join(values, ", ")),
+			Predicate: fmt.Sprintf("%s not in (%s)", col, strings.Join(values, ", ")),
+		})
+	}
+
+	if c.Regex != "" {
+		checks = append(checks, Check{
+			Rule:      REGEX_RULE,
+			Column:    c.Name,
+			Expect:    fmt.Sprintf("matches %s", c.Regex),
+			Predicate: fmt.Sprintf("not regexp_matches(%s::varchar, %s)", col, literal(c.Regex)),
+		})
+	}
+
+	if c.Min != nil || c.Max != nil {
+		var expect, predicate []string
+		if c.Min != nil {
+			expect = append(expect, fmt.Sprintf(">= %s", literal(c.Min)))
+			predicate = append(predicate, fmt.Sprintf("%s < %s", col, literal(c.Min)))
+		}
+		if c.Max != nil {
+			expect = append(expect, fmt.Sprintf("<= %s", literal(c.Max)))
+			predicate = append(predicate, fmt.Sprintf("%s > %s", col, literal(c.Max)))
+		}
+		checks = append(checks, Check{
+			Rule:      RANGE_RULE,
+			Column:    c.Name,
+			Expect:    strings.Join(expect, " and "),
+			Predicate: strings.Join(predicate, " or "),
+		})
+	}
+
+	if c.References != nil {
+		ref, err := utils.ParseSource(c.References.Source, fmt.Sprintf("ref_%d", i))
+		if err != nil {
+			return nil, err
+		}
+		// a database can only be attached once, a reference to another
+		// table of it reads from the same attachment
+		if ref.Kind != utils.FILE_SOURCE && ref.Kind == source.Kind && ref.Path == source.Path {
+			ref.Database = source.Database
+		}
+		// nulls in the referenced column would make every not in null
+		refCol := utils.QuoteIdent(c.References.Column)
+		checks = append(checks, Check{
+			Rule:      REFERENCES_RULE,
+			Column:    c.Name,
+			Expect:    fmt.Sprintf("in %s(%s)", ref, c.References.Column),
+			Predicate: fmt.Sprintf("%s not in (select %s from %s where %[2]s is not null)", col, refCol, ref.Relation()),
+			Reference: &ref,
+		})
+	}
+
+	return checks, nil
+}
+
+// generateCheckSQL selects a sample of the rows failing a check along with
+// the count of every failing row, nulls only fail the not null rule
+func generateCheckSQL(check Check, source utils.Source, limit int) string {
+	var setup string
+	if check.Reference != nil {
+		setup = check.Reference.Setup()
+	}
+
+	col := utils.QuoteIdent(check.Column)
+	failing := fmt.Sprintf("select * from %s where %s is not null and (%s)", source.Relation(), col, check.Predicate)
+	switch {
+	case check.Rule == NOT_NULL_RULE:
+		failing = fmt.Sprintf("select * from %s where %s", source.Relation(), check.Predicate)
+	case check.Rule == UNIQUE_RULE:
+		failing = fmt.Sprintf(
+			"select * from %[1]s where %[2]s is not null qualify count(*) over (partition by %[2]s) > 1 order by %[2]s, columns(*)",
+			source.Relation(),
+			col,
+		)
+	}
+
+	return fmt.Sprintf(
+		"%s%sselect count(*) over () as failed_rows, * from (%s) limit %d",
+		source.Setup(),
+		setup,
+		failing,
+		limit,
+	)
+}
+
+func generateRowCountSQL(source utils.Source) string {
+	return fmt.Sprintf("%sselect count(*) from %s", source.Setup(), source.Relation())
+}
+
+func (b Bounds) String() string {
+	var expect []string
+	if b.Min != nil {
+		expect = append(expect, fmt.Sprintf(">= %d", *b.Min))
+	}
+	if b.Max != nil {
+		expect = append(expect, fmt.Sprintf("<= %d", *b.Max))
+	}
+	return strings.Join(expect, " and ")
+}
+
+func (b Bounds) Contains(n int) bool {
+	return (b.Min == nil || n >= *b.Min) && (b.Max == nil || n <= *b.Max)
+}
@@ -1,11 +1,14 @@
 package diff
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
 	"strings"
 
 	"dct/cmd/utils"
@@ -19,6 +22,11 @@ var (
 	writer        io.Writer
 	metrics       string
 	all           bool
+	rows          bool
+	groupBy       string
+	sampleKeys    string
+	sample        float64
+	noColor       bool
 )
 
 type key struct {
@@ -38,24 +46,42 @@ type Metric struct {
 }
 
 func init() {
-	DiffCmd.Flags().StringVarP(&output, "output", "o", "", "Output comparison to file")
+	DiffCmd.Flags().StringVarP(&output, "output", "o", "",
+		`Output comparison to file as csv, the views of --group-by and --rows are
+  written next to it in files named after it e.g. out-groups.csv and out-rows.csv`)
 	DiffCmd.Flags().StringVarP(&metrics, "metrics", "m", "",
 		`Metrics specification for comparison, using JSON format:
   [{"agg": "mean", "left": "a", "right": "b"}, {"agg": "count_distinct", "left": "c"}]
   Supported aggregations: mean, median, min, max, count_distinct`)
 
 	DiffCmd.Flags().BoolVarP(&all, "all", "a", false, "Show all rows, not just differences")
+	DiffCmd.Flags().StringVarP(&groupBy, "group-by", "g", "",
+		`Dimensions to drill down by, in key format: left_col[=right_col],...
+  Counts and metrics are compared in total, then per dimension, ranked by contribution to the count delta`)
+	DiffCmd.Flags().StringVar(&sampleKeys, "sample-keys", "",
+		`Only compare a deterministic sample of keys, as a percentage or fraction: 1% or 0.01
+  Keys are sampled by hashing their text so the same keys are chosen on both sides`)
+	DiffCmd.Flags().BoolVarP(&rows, "rows", "r", false, "Show changed rows side by side for mismatched keys")
+	DiffCmd.Flags().BoolVar(&noColor, "no-color", false, "Mark changed cells with [-removed-] and {+added+} instead of colour")
 }
 
 var DiffCmd = &cobra.Command{
-	Use:   "diff <keys> <file1> <file2>",
+	Use:   "diff <keys> <source1> <source2>",
 	Short: "Compare files with key matching",
 	Long: `Compare two files using key matching and metric calculations. 
 	Specify keys in format: left_key[=right_key] (comma-separated for multiple keys)
-	Use --metrics to define comparison metrics and --all to show all differences`,
+	Keys may be SQL expressions to match differently typed columns:
+	customer_id::bigint=lpad(cust_id::varchar, 10, '0'),lower(email)=email
+	Either side may be a database table or query instead of a file:
+	sqlite:///path.db?table=orders or duckdb:///path.duckdb?query=select ...
+	Use --metrics to define comparison metrics and --all to show all differences
+	Use --group-by to find which segments contribute to a difference
+	Use --sample-keys to estimate the mismatch rate of large files from a sample of keys
+	Use --rows to show the changed rows side by side`,
 	Args: cobra.MatchAll(cobra.ExactArgs(3), cobra.OnlyValidArgs),
 	Run: func(cmd *cobra.Command, args []string) {
 		keys, left, right := parseArgs(args)
+		validateKeys(keys, left, right)
 
 		var err error
 		writer = defaultWriter
@@ -71,30 +97,113 @@ var DiffCmd = &cobra.Command{
 			metricConf = parseMetrics(metrics)
 		}
 
+		if sampleKeys != "" {
+			sample = parseSample(sampleKeys)
+		}
+
 		diff(keys, left, right, metricConf, writer)
+
+		if groupBy != "" {
+			view := viewWriter("groups")
+			diffGroups(parseKeys(groupBy), left, right, metricConf, view)
+			closeView("groups", view)
+		}
+
+		if rows {
+			view := viewWriter("rows")
+			diffRows(keys, left, right, view)
+			closeView("rows", view)
+		}
 	},
 }
 
-func parseArgs(args []string) (keys keySpec, left, right string) {
-	return parseKeys(args[0]), args[1], args[2]
+// viewWriter is where a view other than the summary is written, a file named
+// after --output so every view is kept when writing to files. Writes are
+// buffered and a failed one is returned when the view is closed
+func viewWriter(view string) io.WriteCloser {
+	if output == "" {
+		return &viewFile{Writer: bufio.NewWriter(writer)}
+	}
+
+	ext := path.Ext(output)
+	file := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(output, ext), view, ext)
+	f, err := os.Create(file)
+	if err != nil {
+		log.Printf("Warning: failed to create %s file defaulting to %v\n", view, defaultWriter)
+		return &viewFile{Writer: bufio.NewWriter(defaultWriter)}
+	}
+	return &viewFile{Writer: bufio.NewWriter(f), file: f}
+}
+
+type viewFile struct {
+	*bufio.Writer
+	file *os.File
+}
+
+func (v *viewFile) Close() error {
+	err := v.Flush()
+	if v.file != nil {
+		err = errors.Join(err, v.file.Close())
+	}
+	return err
+}
+
+func closeView(view string, w io.Closer) {
+	if err := w.Close(); err != nil {
+		log.Fatalf("Error: failed to write %s view: %v\n", view, err)
+	}
+}
+
+func parseArgs(args []string) (keys keySpec, left, right utils.Source) {
+	left, err := utils.ParseSource(args[1], "left")
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	right, err = utils.ParseSource(args[2], "right")
+	if err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	// a database can only be attached once
+	if left.Kind != utils.FILE_SOURCE && left.Kind == right.Kind && left.Path == right.Path {
+		right.Database = left.Database
+	}
+
+	return parseKeys(args[0]), left, right
 }
 
 func parseKeys(keyString string) keySpec {
-	parts := strings.Split(keyString, ",")
+	parts := splitTopLevel(keyString, ',')
 	var keys []key
+	aliases := make(map[string]int)
 
 	for i, part := range parts {
-		segments := strings.Split(part, "=")
+		segments := splitTopLevel(part, '=')
 		var k key
 		switch s := len(segments); s {
 		case 1:
-			k = key{left: segments[0], right: segments[0], alias: segments[0]}
+			k = key{left: segments[0], right: segments[0]}
 		case 2:
-			k = key{left: segments[0], right: segments[1], alias: segments[0]}
+			k = key{left: segments[0], right: segments[1]}
 		default:
 			log.Fatalf("Error: malformed keys at %d: %s\n", i, keyString)
 		}
 
+		k.left, k.right = strings.TrimSpace(k.left), strings.TrimSpace(k.right)
+		k.alias = keyAlias(k.left)
+		if k.alias == "" || k.left == "" || k.right == "" {
+			log.Fatalf("Error: malformed keys at %d: %s\n", i, keyString)
+		}
+
+		// keys built from the same column need distinct names
+		if n := aliases[k.alias]; n > 0 {
+			aliases[k.alias]++
+			k.alias = fmt.Sprintf("%s_%d", k.alias, n)
+		} else {
+			aliases[k.alias] = 1
+		}
+
 		keys = append(keys, k)
 	}
 
@@ -119,17 +228,20 @@ func parseMetrics(metricString string) []Metric {
 	return spec
 }
 
-func generateKeySQL(spec keySpec) (left, right string) {
+func generateKeySQL(spec keySpec) (left, right, aliases string) {
 	for i, key := range spec.keys {
-		left += key.left
-		right += fmt.Sprintf("%s as %s", key.right, key.left)
+		alias := utils.QuoteIdent(key.alias)
+		left += fmt.Sprintf("%s as %s", key.left, alias)
+		right += fmt.Sprintf("%s as %s", key.right, alias)
+		aliases += alias
 		if i < len(spec.keys)-1 {
 			left += ", "
 			right += ", "
+			aliases += ", "
 		}
 	}
 
-	return left, right
+	return left, right, aliases
 }
 
 func generateMetricSQL(spec []Metric) (left, right, main, check string) {
@@ -182,14 +294,23 @@ func generateMetricSQL(spec []Metric) (left, right, main, check string) {
 	return left, right, main, check
 }
 
-func generateSQL(keys keySpec, left, right string, metrics []Metric) string {
-	leftKeys, rightKeys := generateKeySQL(keys)
-	leftMetrics, rightMetrics, mainMetrics, checkMetrics := generateMetricSQL(metrics)
-	leftSQL := fmt.Sprintf("select %s, count(*) as l_cnt, %s from '%s' group by all", leftKeys, leftMetrics, left)
-	rightSQL := fmt.Sprintf("select %s, count(*) as r_cnt, %s from '%s' group by all", rightKeys, rightMetrics, right)
+func generateSideSQL(keys keySpec, left, right utils.Source, metrics []Metric) (leftSQL, rightSQL string) {
+	leftKeys, rightKeys, _ := generateKeySQL(keys)
+	leftMetrics, rightMetrics, _, _ := generateMetricSQL(metrics)
+	leftSample, rightSample := generateSampleFilterSQL(keys, sample)
+	leftSQL = fmt.Sprintf("select %s, count(*) as l_cnt, %s from %s %sgroup by all", leftKeys, leftMetrics, left.Relation(), leftSample)
+	rightSQL = fmt.Sprintf("select %s, count(*) as r_cnt, %s from %s %sgroup by all", rightKeys, rightMetrics, right.Relation(), rightSample)
+
+	return leftSQL, rightSQL
+}
+
+func generateSQL(keys keySpec, left, right utils.Source, metrics []Metric) string {
+	_, _, aliases := generateKeySQL(keys)
+	_, _, mainMetrics, checkMetrics := generateMetricSQL(metrics)
+	leftSQL, rightSQL := generateSideSQL(keys, left, right, metrics)
 
 	sql := fmt.Sprintf(
-		`with file1 as (
+		`%s%swith file1 as (
   %s
 ), file2 as (
   %s
@@ -199,25 +320,27 @@ from file1
 full join file2 using (%s)
 where l_cnt <> r_cnt %s
 order by %s`,
+		left.Setup(),
+		right.Setup(),
 		leftSQL,
 		rightSQL,
-		leftKeys,
+		aliases,
 		mainMetrics,
-		leftKeys,
+		aliases,
 		checkMetrics,
-		leftKeys,
+		aliases,
 	)
 
 	return sql
 }
 
-func diff(keys keySpec, left string, right string, metrics []Metric, writer io.Writer) {
-	leftHasRows, err := utils.CheckFileHasRows(left)
+func diff(keys keySpec, left, right utils.Source, metrics []Metric, writer io.Writer) {
+	leftHasRows, err := utils.CheckSourceHasRows(left)
 	if err != nil {
 		log.Fatalf("failed to check file: %v", err)
 	}
 
-	rightHasRows, err := utils.CheckFileHasRows(right)
+	rightHasRows, err := utils.CheckSourceHasRows(right)
 	if err != nil {
 		log.Fatalf("failed to check file: %v", err)
 	}
@@ -238,4 +361,10 @@ func diff(keys keySpec, left string, right string, metrics []Metric, writer io.W
 	} else {
 		_ = result.ToCsv(writer)
 	}
+
+	if sample > 0 {
+		view := viewWriter("sample")
+		reportSample(keys, left, right, metrics, view)
+		closeView("sample", view)
+	}
 }
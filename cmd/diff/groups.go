@@ -0,0 +1,130 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"dct/cmd/utils"
+)
+
+const (
+	TOTAL_GRAIN = "total"
+	GROUP_LIMIT = 10
+)
+
+// generateGrainSQL selects one level of grain from a side of the diff, the
+// dimensions not in the grain are null so every level can be unioned
+func generateGrainSQL(dims keySpec, grain int, prefix string, metrics string, source utils.Source) string {
+	label := TOTAL_GRAIN
+	var cols []string
+	for i, dim := range dims.keys {
+		name := dim.left
+		if prefix == "r" {
+			name = dim.right
+		}
+
+		alias := utils.QuoteIdent(dim.alias)
+		if i == grain {
+			label = dim.alias
+			cols = append(cols, fmt.Sprintf("%s::varchar as %s", name, alias))
+		} else {
+			cols = append(cols, fmt.Sprintf("null::varchar as %s", alias))
+		}
+	}
+
+	return fmt.Sprintf(
+		"select '%s' as grain, %d as grain_order, %s, count(*) as %s_cnt, %s from %s group by all",
+		strings.ReplaceAll(label, "'", "''"),
+		grain+1,
+		strings.Join(cols, ", "),
+		prefix,
+		metrics,
+		source.Relation(),
+	)
+}
+
+func generateGroupSQL(dims keySpec, left, right utils.Source, metrics []Metric, limit int) string {
+	leftMetrics, rightMetrics, mainMetrics, _ := generateMetricSQL(metrics)
+
+	var leftGrains, rightGrains, join, names, aliases []string
+	for grain := -1; grain < len(dims.keys); grain++ {
+		leftGrains = append(leftGrains, generateGrainSQL(dims, grain, "l", leftMetrics, left))
+		rightGrains = append(rightGrains, generateGrainSQL(dims, grain, "r", rightMetrics, right))
+	}
+
+	for _, dim := range dims.keys {
+		alias := utils.QuoteIdent(dim.alias)
+		join = append(join, fmt.Sprintf("file1.%[1]s is not distinct from file2.%[1]s", alias))
+		names = append(names, fmt.Sprintf("coalesce(file1.%[1]s, file2.%[1]s) as %[1]s", alias))
+		aliases = append(aliases, alias)
+	}
+
+	// only the groups contributing most to each level are displayed
+	var qualify string
+	if limit > 0 {
+		qualify = fmt.Sprintf("qualify rank <= %d", limit)
+	}
+
+	return fmt.Sprintf(
+		`%[1]s%[2]swith file1 as (
+  %[3]s
+), file2 as (
+  %[4]s
+), grains as (
+  select
+    coalesce(file1.grain, file2.grain) as grain,
+    coalesce(file1.grain_order, file2.grain_order) as grain_order,
+    %[7]s,
+    l_cnt,
+    r_cnt,
+    coalesce(r_cnt, 0) - coalesce(l_cnt, 0) as cnt_delta,
+    %[8]s
+  from file1
+  full join file2
+    on file1.grain = file2.grain
+    and %[9]s
+)
+select
+  grain,
+  %[6]s,
+  l_cnt,
+  r_cnt,
+  cnt_delta,
+  round(cnt_delta / nullif(sum(cnt_delta) over (partition by grain), 0), 4) as cnt_share,
+  rank() over (partition by grain order by abs(cnt_delta) desc) as rank,
+  * exclude (grain, grain_order, %[6]s, l_cnt, r_cnt, cnt_delta)
+from grains
+%[5]s
+order by grain_order, rank, %[6]s`,
+		left.Setup(),
+		right.Setup(),
+		strings.Join(leftGrains, "\n  union all\n  "),
+		strings.Join(rightGrains, "\n  union all\n  "),
+		qualify,
+		strings.Join(aliases, ", "),
+		strings.Join(names, ",\n    "),
+		mainMetrics,
+		strings.Join(join, "\n    and "),
+	)
+}
+
+func diffGroups(dims keySpec, left, right utils.Source, metrics []Metric, writer io.Writer) {
+	limit := GROUP_LIMIT
+	if output != "" {
+		limit = 0
+	}
+
+	query := generateGroupSQL(dims, left, right, metrics, limit)
+	result, err := utils.Query(query)
+	if err != nil {
+		log.Fatalf("failed to cmp groups: %v", err)
+	}
+
+	if output == "" {
+		_ = result.Render(writer, len(result.Rows))
+	} else {
+		_ = result.ToCsv(writer)
+	}
+}
@@ -0,0 +1,127 @@
+package diff
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"dct/cmd/utils"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$|^"[^"]+"$`)
+
+// splitTopLevel splits on sep outside of brackets and quotes so that key
+// expressions like `lpad(id,10,'0')` stay whole
+func splitTopLevel(s string, sep rune) []string {
+	var parts []string
+	var quote rune
+	depth, start := 0, 0
+
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == sep && depth == 0:
+			// comparison operators are part of the expression
+			if sep == '=' && isComparison(runes, i) {
+				continue
+			}
+			parts = append(parts, string(runes[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(parts, string(runes[start:]))
+}
+
+func isComparison(runes []rune, i int) bool {
+	if i > 0 && strings.ContainsRune("<>!=", runes[i-1]) {
+		return true
+	}
+	return i < len(runes)-1 && runes[i+1] == '='
+}
+
+// keyAlias names a key expression after the first column it references,
+// skipping function names, casts and string literals
+func keyAlias(expr string) string {
+	expr = strings.TrimSpace(expr)
+	if identifier.MatchString(expr) {
+		return strings.Trim(expr, `"`)
+	}
+
+	runes := []rune(expr)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\'':
+			for i++; i < len(runes) && runes[i] != '\''; i++ {
+			}
+		case r == '"':
+			start := i + 1
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+			}
+			return string(runes[start:min(i, len(runes))])
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			word := string(runes[start:i])
+
+			isCast := start >= 2 && string(runes[start-2:start]) == "::"
+			isFunc := i < len(runes) && runes[i] == '('
+			if !isCast && !isFunc {
+				return word
+			}
+			i--
+		}
+	}
+
+	return ""
+}
+
+// validateKeys checks every key expression against the schema of its side and
+// warns when the two sides would be joined on different types
+func validateKeys(keys keySpec, left, right utils.Source) {
+	var leftExprs, rightExprs []string
+	for _, key := range keys.keys {
+		leftExprs = append(leftExprs, key.left)
+		rightExprs = append(rightExprs, key.right)
+	}
+
+	describe := func(exprs []string, source utils.Source, side string) []utils.Header {
+		query := fmt.Sprintf("%sselect %s from %s limit 0", source.Setup(), strings.Join(exprs, ", "), source.Relation())
+		result, err := utils.Query(query)
+		if err != nil {
+			log.Fatalf("Error: invalid keys for %s %s: %v\n", side, source, err)
+		}
+		return result.Headers
+	}
+
+	leftTypes := describe(leftExprs, left, "left")
+	rightTypes := describe(rightExprs, right, "right")
+	for i, key := range keys.keys {
+		if leftTypes[i].Type != rightTypes[i].Type {
+			log.Printf(
+				"Warning: key `%s` is %s on the left and %s on the right, consider a cast e.g. %s::%s=%s\n",
+				key.alias,
+				leftTypes[i].Type,
+				rightTypes[i].Type,
+				key.left,
+				strings.ToLower(rightTypes[i].Type),
+				key.right,
+			)
+		}
+	}
+}
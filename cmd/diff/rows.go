@@ -0,0 +1,274 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"dct/cmd/utils"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	REMOVED        = "removed"
+	ADDED          = "added"
+	ROW_DIFF_LIMIT = 1000
+)
+
+var (
+	removedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Bold(true)
+	addedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2")).Bold(true)
+)
+
+type rowPair struct {
+	left  []any
+	right []any
+}
+
+func readColumns(source utils.Source) []string {
+	result, err := utils.Query(fmt.Sprintf("%sselect * from %s limit 0", source.Setup(), source.Relation()))
+	if err != nil {
+		log.Fatalf("failed to read columns of %s: %v", source, err)
+	}
+
+	var cols []string
+	for _, header := range result.Headers {
+		cols = append(cols, header.Name)
+	}
+
+	return cols
+}
+
+// matchColumns returns the non key columns present in both files, columns
+// used by keys are left out
+func matchColumns(keys keySpec, leftCols, rightCols []string) (cols []string) {
+	leftKeys := make(map[string]bool)
+	rightKeys := make(map[string]bool)
+	for _, key := range keys.keys {
+		leftKeys[keyAlias(key.left)] = true
+		rightKeys[keyAlias(key.right)] = true
+	}
+
+	for _, col := range leftCols {
+		if leftKeys[col] {
+			continue
+		}
+
+		if !slices.Contains(rightCols, col) {
+			log.Printf("Warning: column `%s` is missing from right file, skipping\n", col)
+			continue
+		}
+
+		cols = append(cols, col)
+	}
+
+	for _, col := range rightCols {
+		if !rightKeys[col] && !leftKeys[col] && !slices.Contains(cols, col) {
+			log.Printf("Warning: column `%s` is missing from left file, skipping\n", col)
+		}
+	}
+
+	return cols
+}
+
+func generateRowSQL(keys keySpec, left, right utils.Source, cols []string) string {
+	var leftCols, rightCols, order []string
+	for _, key := range keys.keys {
+		alias := utils.QuoteIdent(key.alias)
+		leftCols = append(leftCols, fmt.Sprintf("%s as %s", key.left, alias))
+		rightCols = append(rightCols, fmt.Sprintf("%s as %s", key.right, alias))
+		order = append(order, alias)
+	}
+	for _, col := range cols {
+		leftCols = append(leftCols, utils.QuoteIdent(col))
+		rightCols = append(rightCols, utils.QuoteIdent(col))
+		order = append(order, utils.QuoteIdent(col))
+	}
+	leftSample, rightSample := generateSampleFilterSQL(keys, sample)
+
+	return fmt.Sprintf(
+		`%s%swith file1 as (
+  select %s from %s %s
+), file2 as (
+  select %s from %s %s
+)
+select * from (
+  select '%s' as side, * from (select * from file1 except all select * from file2)
+  union all
+  select '%s' as side, * from (select * from file2 except all select * from file1)
+)
+order by %s, side desc
+limit %d`,
+		left.Setup(),
+		right.Setup(),
+		strings.Join(leftCols, ", "),
+		left.Relation(),
+		leftSample,
+		strings.Join(rightCols, ", "),
+		right.Relation(),
+		rightSample,
+		REMOVED,
+		ADDED,
+		strings.Join(order, ", "),
+		ROW_DIFF_LIMIT,
+	)
+}
+
+// pairRows lines up removed and added rows sharing a key so that they can be
+// shown as a change, any leftover rows are shown as only removed or added
+func pairRows(result utils.Result, nKeys int) []rowPair {
+	var order []string
+	removed := make(map[string][][]any)
+	added := make(map[string][][]any)
+
+	for _, row := range result.Rows {
+		var keyParts []string
+		for _, v := range row[1 : nKeys+1] {
+			keyParts = append(keyParts, fmt.Sprintf("%v", v))
+		}
+		k := strings.Join(keyParts, "\x00")
+
+		if _, ok := removed[k]; !ok {
+			if _, ok := added[k]; !ok {
+				order = append(order, k)
+			}
+		}
+
+		switch row[0] {
+		case REMOVED:
+			removed[k] = append(removed[k], row[1:])
+		case ADDED:
+			added[k] = append(added[k], row[1:])
+		}
+	}
+
+	var pairs []rowPair
+	for _, k := range order {
+		l, r := removed[k], added[k]
+		for i := range max(len(l), len(r)) {
+			var pair rowPair
+			if i < len(l) {
+				pair.left = l[i]
+			}
+			if i < len(r) {
+				pair.right = r[i]
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return pairs
+}
+
+func markCell(value any, side string) string {
+	if side == REMOVED {
+		return fmt.Sprintf("[-%v-]", value)
+	}
+	return fmt.Sprintf("{+%v+}", value)
+}
+
+// renderRows draws keys, then left and right values side by side, styling
+// changed cells like `git diff --word-diff`
+func renderRows(pairs []rowPair, cols []string, nKeys int, writer io.Writer) error {
+	var headers []utils.Header
+	for _, col := range cols[:nKeys] {
+		headers = append(headers, utils.Header{Name: col, Type: "KEY"})
+	}
+	for _, col := range cols[nKeys:] {
+		headers = append(headers, utils.Header{Name: "l_" + col, Type: "LEFT"})
+	}
+	for _, col := range cols[nKeys:] {
+		headers = append(headers, utils.Header{Name: "r_" + col, Type: "RIGHT"})
+	}
+
+	width := len(cols) - nKeys
+	styles := make([][]*lipgloss.Style, len(pairs))
+	var rows [][]any
+	for i, pair := range pairs {
+		row := make([]any, len(headers))
+		styles[i] = make([]*lipgloss.Style, len(headers))
+
+		keys := pair.left
+		if keys == nil {
+			keys = pair.right
+		}
+		copy(row, keys[:nKeys])
+
+		for j := range width {
+			lIdx, rIdx := nKeys+j, nKeys+width+j
+			row[lIdx], row[rIdx] = "", ""
+
+			var changed bool
+			switch {
+			case pair.left != nil && pair.right != nil:
+				changed = fmt.Sprintf("%v", pair.left[nKeys+j]) != fmt.Sprintf("%v", pair.right[nKeys+j])
+				row[lIdx], row[rIdx] = pair.left[nKeys+j], pair.right[nKeys+j]
+			case pair.left != nil:
+				changed = true
+				row[lIdx] = pair.left[nKeys+j]
+			case pair.right != nil:
+				changed = true
+				row[rIdx] = pair.right[nKeys+j]
+			}
+
+			if !changed {
+				continue
+			}
+
+			if pair.left != nil {
+				if noColor {
+					row[lIdx] = markCell(row[lIdx], REMOVED)
+				} else {
+					styles[i][lIdx] = &removedStyle
+				}
+			}
+			if pair.right != nil {
+				if noColor {
+					row[rIdx] = markCell(row[rIdx], ADDED)
+				} else {
+					styles[i][rIdx] = &addedStyle
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	result := utils.Result{Headers: headers, Rows: rows}
+	return result.RenderStyled(writer, len(rows), func(row, col int, base lipgloss.Style) lipgloss.Style {
+		if s := styles[row][col]; s != nil {
+			return base.Inherit(*s)
+		}
+		return base
+	})
+}
+
+func diffRows(keys keySpec, left, right utils.Source, writer io.Writer) {
+	cols := matchColumns(keys, readColumns(left), readColumns(right))
+	query := generateRowSQL(keys, left, right, cols)
+	result, err := utils.Query(query)
+	if err != nil {
+		log.Fatalf("failed to cmp rows: %v", err)
+	}
+
+	if len(result.Rows) == ROW_DIFF_LIMIT {
+		log.Printf("Warning: showing only the first %d changed rows\n", ROW_DIFF_LIMIT)
+	}
+
+	if output != "" {
+		_ = result.ToCsv(writer)
+		return
+	}
+
+	var display []string
+	for _, key := range keys.keys {
+		display = append(display, key.alias)
+	}
+	display = append(display, cols...)
+
+	nKeys := len(keys.keys)
+	_ = renderRows(pairRows(result, nKeys), display, nKeys, writer)
+}
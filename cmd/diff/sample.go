@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"dct/cmd/utils"
+)
+
+const (
+	SAMPLE_BUCKETS = 1_000_000
+	// z score for a 95% confidence interval
+	Z_95 = 1.959964
+)
+
+func parseSample(sampleString string) float64 {
+	value, isPct := strings.CutSuffix(strings.TrimSpace(sampleString), "%")
+	fraction, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Error: failed to parse sample: %s\n", sampleString)
+	}
+
+	if isPct {
+		fraction /= 100
+	}
+
+	if fraction <= 0 || fraction > 1 {
+		log.Fatalf("Error: sample must be between 0%% and 100%%: %s\n", sampleString)
+	}
+
+	return fraction
+}
+
+// generateSampleFilterSQL keeps keys whose hash falls in the sample, keys are
+// hashed as text so both sides choose the same keys when their types differ
+func generateSampleFilterSQL(keys keySpec, fraction float64) (left, right string) {
+	if fraction <= 0 {
+		return "", ""
+	}
+
+	var leftKeys, rightKeys []string
+	for _, key := range keys.keys {
+		leftKeys = append(leftKeys, fmt.Sprintf("(%s)::varchar", key.left))
+		rightKeys = append(rightKeys, fmt.Sprintf("(%s)::varchar", key.right))
+	}
+
+	buckets := int(math.Round(fraction * SAMPLE_BUCKETS))
+	filter := "where hash(%s) %% %d < %d "
+	left = fmt.Sprintf(filter, strings.Join(leftKeys, ", "), SAMPLE_BUCKETS, buckets)
+	right = fmt.Sprintf(filter, strings.Join(rightKeys, ", "), SAMPLE_BUCKETS, buckets)
+
+	return left, right
+}
+
+func generateSampleSQL(keys keySpec, left, right utils.Source, metrics []Metric) string {
+	_, _, aliases := generateKeySQL(keys)
+	_, _, mainMetrics, _ := generateMetricSQL(metrics)
+	leftSQL, rightSQL := generateSideSQL(keys, left, right, metrics)
+
+	var eqs []string
+	for _, metric := range metrics {
+		eqs = append(eqs, fmt.Sprintf("and %s_%s_eq", metric.Left, metric.Agg))
+	}
+
+	return fmt.Sprintf(
+		`%s%swith file1 as (
+  %s
+), file2 as (
+  %s
+), cmp as (
+  select %s, coalesce(l_cnt = r_cnt, false) as cnt_eq, %s
+  from file1
+  full join file2 using (%s)
+)
+select count(*) as sampled_keys, count(*) filter (where not (cnt_eq %s)) as mismatched_keys
+from cmp`,
+		left.Setup(),
+		right.Setup(),
+		leftSQL,
+		rightSQL,
+		aliases,
+		mainMetrics,
+		aliases,
+		strings.Join(eqs, " "),
+	)
+}
+
+// wilson returns the Wilson score interval for a binomial proportion
+func wilson(successes, n int, z float64) (low, high float64) {
+	if n == 0 {
+		return 0, 1
+	}
+
+	p := float64(successes) / float64(n)
+	total := float64(n)
+	denom := 1 + z*z/total
+	centre := (p + z*z/(2*total)) / denom
+	half := z * math.Sqrt(p*(1-p)/total+z*z/(4*total*total)) / denom
+
+	return math.Max(0, centre-half), math.Min(1, centre+half)
+}
+
+func round(f float64) float64 {
+	return math.Round(f*10_000) / 10_000
+}
+
+func reportSample(keys keySpec, left, right utils.Source, metrics []Metric, writer io.Writer) {
+	result, err := utils.Query(generateSampleSQL(keys, left, right, metrics))
+	if err != nil {
+		log.Fatalf("failed to sample keys: %v", err)
+	}
+
+	sampled, _ := result.Rows[0][0].(int)
+	mismatched, _ := result.Rows[0][1].(int)
+
+	var rate float64
+	if sampled > 0 {
+		rate = float64(mismatched) / float64(sampled)
+	}
+	low, high := wilson(mismatched, sampled, Z_95)
+
+	summary := utils.Result{
+		Headers: []utils.Header{
+			{Name: "sample", Type: "VARCHAR"},
+			{Name: "sampled_keys", Type: "BIGINT"},
+			{Name: "mismatched_keys", Type: "BIGINT"},
+			{Name: "mismatch_rate", Type: "DOUBLE"},
+			{Name: "ci_95_low", Type: "DOUBLE"},
+			{Name: "ci_95_high", Type: "DOUBLE"},
+		},
+		Rows: [][]any{{sampleKeys, sampled, mismatched, round(rate), round(low), round(high)}},
+	}
+	if output != "" {
+		_ = summary.ToCsv(writer)
+		return
+	}
+	_ = summary.Render(writer, 1)
+}
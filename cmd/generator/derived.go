@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"reflect"
+	"maps"
+	"slices"
+	"time"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 )
 
 type DerivedField struct {
@@ -15,7 +18,10 @@ type DerivedField struct {
 	Config struct {
 		Expression string   `json:"expression"`
 		Fields     []string `json:"fields"`
+		Type       string   `json:"type"`
 	} `json:"config"`
+
+	program *vm.Program
 }
 
 var env = map[string]any{
@@ -24,41 +30,79 @@ var env = map[string]any{
 	},
 }
 
-func (s DerivedField) Generate(ctx context.Context) any {
+// compile checks the expression against the types of the fields it reads
+func (s *DerivedField) compile(ctx context.Context) (*vm.Program, error) {
 	fieldMap := ctx.Value(FIELD_MAP_KEY).(FieldMap)
 	schema := ctx.Value(SCHEMA_KEY).(Schema)
-	fieldPtrs := make(map[string]reflect.Value)
+	typeEnv := maps.Clone(env)
 	for _, f := range s.Config.Fields {
-		idx := reflect.ValueOf(fieldMap).MapIndex(reflect.ValueOf(f)).Int()
-		fieldPtrs[f] = reflect.ValueOf(schema).Index(int(idx))
+		typeEnv[f] = schema[fieldMap[f]].Type(ctx).zero()
 	}
 
-	for k, v := range fieldPtrs {
-		field := v.Elem().Interface().(Field)
-		cacheValue := cache.GetValue(field.GetName())
-		switch v := cacheValue.(type) {
-		case bool, int, int32, int64, float32, float64, string:
-			env[k] = v
-		default:
-			log.Fatalf("unimplemented type used in derived field: %T", v)
-		}
+	program, err := expr.Compile(s.Config.Expression, expr.Env(typeEnv))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile expression `%s` for field %s: %v", s.Config.Expression, s.Field, err)
+	}
+	return program, nil
+}
+
+func (s *DerivedField) prepare(ctx context.Context) error {
+	if s.Config.Expression == "" {
+		return fmt.Errorf("expected an expression in the config of field %s", s.Field)
+	}
+	if s.Config.Type != "" && !slices.Contains(LOGICAL_TYPES, s.Config.Type) {
+		return fmt.Errorf("unsupported type `%s` for field %s, expected one of %v", s.Config.Type, s.Field, LOGICAL_TYPES)
 	}
 
-	program, err := expr.Compile(
-		s.Config.Expression,
-		expr.Env(env),
-	)
+	program, err := s.compile(ctx)
 	if err != nil {
-		log.Fatalf(
-			"failed to execute expression `%s` for field %s: %v",
-			s.Config.Expression, s.Source, err,
-		)
+		return err
+	}
+	s.program = program
+	return nil
+}
+
+// Generate runs the expression on the values of the fields it reads in the
+// current row
+func (s *DerivedField) Generate(ctx context.Context) any {
+	values := maps.Clone(env)
+	for _, f := range s.Config.Fields {
+		switch v := valueOf(ctx, f).(type) {
+		case bool, int, int32, int64, float32, float64, string, time.Time:
+			values[f] = v
+		default:
+			log.Fatalf("unimplemented type used in derived field: %T", v)
+		}
 	}
 
-	o, _ := expr.Run(program, env)
+	o, _ := expr.Run(s.program, values)
+	putValue(ctx, s.Field, o)
 	return o
 }
 
-func (s DerivedField) GetName() string {
+func (s *DerivedField) GetName() string {
 	return s.Field
 }
+
+// Type is declared in config or taken from the expression, checked against
+// the types of the fields it reads, a failed expression is null
+func (s *DerivedField) Type(ctx context.Context) LogicalType {
+	if s.Config.Type != "" {
+		if !slices.Contains(LOGICAL_TYPES, s.Config.Type) {
+			log.Fatalf("unsupported type `%s` for field %s, expected one of %v", s.Config.Type, s.Field, LOGICAL_TYPES)
+		}
+		return LogicalType{Name: s.Config.Type, Nullable: true}
+	}
+
+	program := s.program
+	if program == nil {
+		var err error
+		if program, err = s.compile(ctx); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	t := typeOf(program.Node().Type())
+	t.Nullable = true
+	return t
+}
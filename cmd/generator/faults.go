@@ -0,0 +1,255 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	WHITESPACE_CORRUPTION     = "whitespace"
+	WRONG_TYPE_CORRUPTION     = "wrongType"
+	OUT_OF_RANGE_CORRUPTION   = "outOfRange"
+	MALFORMED_DATE_CORRUPTION = "malformedDate"
+	CONFUSABLES_CORRUPTION    = "confusables"
+
+	// duplicates are drawn from a reservoir of earlier values so memory stays
+	// flat however many lines are generated
+	DUPLICATE_POOL_SIZE = 1000
+)
+
+var CORRUPTIONS = []string{
+	WHITESPACE_CORRUPTION,
+	WRONG_TYPE_CORRUPTION,
+	OUT_OF_RANGE_CORRUPTION,
+	MALFORMED_DATE_CORRUPTION,
+	CONFUSABLES_CORRUPTION,
+}
+
+var (
+	WHITESPACE_PADDING     = []string{" ", "  ", "\t"}
+	WRONG_TYPE_VALUES      = []string{"N/A", "unknown", "-", "?", "#VALUE!"}
+	MALFORMED_DATE_LAYOUTS = []string{"02/01/2006", "2006/1/2", "Jan 2, 2006", "20060102", "2006-01-02T15:04"}
+	CONFUSABLES            = map[rune]rune{
+		'a': 'а', 'c': 'с', 'e': 'е', 'i': 'і', 'o': 'о', 'p': 'р', 'x': 'х', 'y': 'у',
+		'A': 'А', 'B': 'В', 'C': 'С', 'E': 'Е', 'H': 'Н', 'K': 'К', 'M': 'М', 'O': 'О', 'P': 'Р', 'T': 'Т', 'X': 'Х',
+	}
+)
+
+// Faults is the config shared by every source for writing dirty data, the
+// rates are the chance of each fault on a value and add up to at most 1
+type Faults struct {
+	NullRate      float64  `json:"nullRate"`
+	EmptyRate     float64  `json:"emptyRate"`
+	DuplicateRate float64  `json:"duplicateRate"`
+	CorruptRate   float64  `json:"corruptRate"`
+	Corruptions   []string `json:"corruptions"`
+}
+
+func (f Faults) IsEmpty() bool {
+	return f.NullRate == 0 && f.EmptyRate == 0 && f.DuplicateRate == 0 && f.CorruptRate == 0
+}
+
+func (f Faults) validate(field string) error {
+	rates := []struct {
+		name string
+		rate float64
+	}{
+		{"nullRate", f.NullRate},
+		{"emptyRate", f.EmptyRate},
+		{"duplicateRate", f.DuplicateRate},
+		{"corruptRate", f.CorruptRate},
+	}
+	for _, r := range rates {
+		if r.rate < 0 || r.rate > 1 {
+			return fmt.Errorf("expected %s of field %s to be between 0 and 1: %g", r.name, field, r.rate)
+		}
+	}
+	if total := f.NullRate + f.EmptyRate + f.DuplicateRate + f.CorruptRate; total > 1 {
+		return fmt.Errorf("expected the rates of field %s to add up to at most 1: %g", field, total)
+	}
+
+	for _, c := range f.Corruptions {
+		if !slices.Contains(CORRUPTIONS, c) {
+			return fmt.Errorf("unsupported corruption `%s` for field %s, expected one of %v", c, field, CORRUPTIONS)
+		}
+	}
+	return nil
+}
+
+// withFaults wraps a field when its config asks for faults
+func withFaults(field Field, faults Faults) (Field, error) {
+	if faults.IsEmpty() {
+		return field, nil
+	}
+	if err := faults.validate(field.GetName()); err != nil {
+		return nil, err
+	}
+	if len(faults.Corruptions) == 0 {
+		faults.Corruptions = CORRUPTIONS
+	}
+
+	return &FaultyField{Field: field, Faults: faults}, nil
+}
+
+// FaultyField replaces some values of a field with nulls, empty strings,
+// earlier values or corrupted values. The field always generates its value
+// first so derived fields read clean values and the random draws of the
+// field are the same with or without faults
+type FaultyField struct {
+	Field
+	Faults     Faults
+	duplicates []any
+	seen       int
+	// types of the wrapped field and as written, derived types are costly to
+	// work out so they are kept before the first value
+	fieldType   LogicalType
+	writtenType LogicalType
+}
+
+func (s *FaultyField) prepare(ctx context.Context) error {
+	if err := prepareField(ctx, s.Field); err != nil {
+		return err
+	}
+	s.fieldType = s.Field.Type(ctx)
+	s.writtenType = s.Type(ctx)
+	s.duplicates, s.seen = nil, 0
+	return nil
+}
+
+func (s *FaultyField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := s.Field.Generate(ctx)
+
+	result := value
+	p := r.Float64()
+	switch {
+	case p < s.Faults.NullRate:
+		result = nil
+	case p < s.Faults.NullRate+s.Faults.EmptyRate:
+		result = ""
+	case p < s.Faults.NullRate+s.Faults.EmptyRate+s.Faults.DuplicateRate:
+		if len(s.duplicates) > 0 {
+			result = s.duplicates[r.IntN(len(s.duplicates))]
+		}
+	case p < s.Faults.NullRate+s.Faults.EmptyRate+s.Faults.DuplicateRate+s.Faults.CorruptRate:
+		result = s.corrupt(ctx, s.fieldType, value)
+	}
+
+	s.remember(ctx, value)
+
+	// clean values are formatted as the field would be e.g. dates without a
+	// time, when faults turn the field into text
+	if result != nil && s.writtenType.Name != s.fieldType.Name {
+		return text(s.fieldType, result)
+	}
+	return result
+}
+
+// remember keeps a uniform sample of the values generated so far
+func (s *FaultyField) remember(ctx context.Context, value any) {
+	if value == nil || s.Faults.DuplicateRate == 0 {
+		return
+	}
+
+	s.seen++
+	if len(s.duplicates) < DUPLICATE_POOL_SIZE {
+		s.duplicates = append(s.duplicates, value)
+	} else if i := random(ctx).IntN(s.seen); i < DUPLICATE_POOL_SIZE {
+		s.duplicates[i] = value
+	}
+}
+
+// corrupt applies one of the corruptions, those that don't apply to a value
+// e.g. malformedDate on a number leave it as is
+func (s *FaultyField) corrupt(ctx context.Context, t LogicalType, value any) any {
+	if value == nil {
+		return nil
+	}
+
+	r := random(ctx)
+	switch s.Faults.Corruptions[r.IntN(len(s.Faults.Corruptions))] {
+	case WHITESPACE_CORRUPTION:
+		pad := WHITESPACE_PADDING[r.IntN(len(WHITESPACE_PADDING))]
+		switch r.IntN(3) {
+		case 0:
+			return pad + text(t, value)
+		case 1:
+			return text(t, value) + pad
+		default:
+			return pad + text(t, value) + pad
+		}
+	case WRONG_TYPE_CORRUPTION:
+		if t.Name == STRING_TYPE {
+			return r.IntN(1000)
+		}
+		return WRONG_TYPE_VALUES[r.IntN(len(WRONG_TYPE_VALUES))]
+	case OUT_OF_RANGE_CORRUPTION:
+		switch v := value.(type) {
+		case int:
+			return -(v + 1) * 1000
+		case float64:
+			return -(v + 1) * 1000
+		case time.Time:
+			if t.Name != TIME_TYPE {
+				return v.AddDate(1000, 0, 0)
+			}
+		}
+	case MALFORMED_DATE_CORRUPTION:
+		if v, ok := value.(time.Time); ok {
+			return v.Format(MALFORMED_DATE_LAYOUTS[r.IntN(len(MALFORMED_DATE_LAYOUTS))])
+		}
+	case CONFUSABLES_CORRUPTION:
+		if v, ok := value.(string); ok {
+			return confuse(r.IntN, v)
+		}
+	}
+	return value
+}
+
+// confuse swaps one letter for a unicode lookalike e.g. a latin a for a
+// cyrillic а
+func confuse(intN func(int) int, value string) string {
+	runes := []rune(value)
+	var swappable []int
+	for i, c := range runes {
+		if _, ok := CONFUSABLES[c]; ok {
+			swappable = append(swappable, i)
+		}
+	}
+	if len(swappable) == 0 {
+		return value
+	}
+
+	i := swappable[intN(len(swappable))]
+	runes[i] = CONFUSABLES[runes[i]]
+	return string(runes)
+}
+
+// Type is the type of the field, written as text when faults can make values
+// of other types strings
+func (s *FaultyField) Type(ctx context.Context) LogicalType {
+	t := s.Field.Type(ctx)
+	if s.Faults.NullRate > 0 {
+		t.Nullable = true
+	}
+	if t.Name != STRING_TYPE && s.writesText(t) {
+		t.Name = STRING_TYPE
+	}
+	return t
+}
+
+func (s *FaultyField) writesText(t LogicalType) bool {
+	if s.Faults.EmptyRate > 0 {
+		return true
+	}
+	if s.Faults.CorruptRate == 0 {
+		return false
+	}
+
+	isTime := t.Name == DATE_TYPE || t.Name == TIME_TYPE || t.Name == TIMESTAMP_TYPE
+	return slices.ContainsFunc(s.Faults.Corruptions, func(c string) bool {
+		return c == WHITESPACE_CORRUPTION || c == WRONG_TYPE_CORRUPTION || (c == MALFORMED_DATE_CORRUPTION && isTime)
+	})
+}
@@ -1,14 +1,20 @@
 package generator
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"math"
 	"math/rand/v2"
 	"os"
-	"reflect"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"dct/cmd/generator/sources"
@@ -16,88 +22,240 @@ import (
 	"github.com/google/uuid"
 )
 
-func ParseField[T Field](raw []byte) *T {
-	var parsedField *T
-	err := json.Unmarshal(raw, &parsedField)
-	if err != nil {
-		log.Fatalf(
-			"failed to parse schema field in '%s'\n",
-			string(raw),
-		)
+// categorical distributions of randomEnum
+const ZIPF_DISTRIBUTION = "zipf"
+
+// ParseField reads a field of type T, fields that keep state between values
+// e.g. sequences implement Field on *T. Keys T doesn't have are an error so
+// typos in a config aren't silently ignored
+func ParseField[T any, PT interface {
+	*T
+	Field
+}](raw []byte) (PT, error) {
+	parsedField := PT(new(T))
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(parsedField); err != nil {
+		return nil, jsonError(err)
 	}
 
-	return parsedField
+	return parsedField, nil
 }
 
-func parseSchema(rawSchema string) Schema {
-	var schema []byte
-	var err error
-
+// readSchema reads a schema given inline or as a file
+func readSchema(rawSchema string) []byte {
 	if json.Valid([]byte(rawSchema)) {
-		schema = []byte(rawSchema)
-	} else {
-		schema, err = os.ReadFile(rawSchema)
+		return []byte(rawSchema)
 	}
 
+	schema, err := os.ReadFile(rawSchema)
 	if err != nil {
-		log.Fatalf("failed to parse schema file '%v': %v\n", rawSchema, schema)
+		log.Fatalf("failed to parse schema file '%v': %v\n", rawSchema, err)
 	}
+	return schema
+}
 
-	var fields []any
-	err = json.Unmarshal(schema, &fields)
-	if err != nil {
-		log.Fatalf("failed to parse schema: %v\n", err)
+// parseSchema reads the fields of a schema, every field that can't be read
+// is reported rather than the first. The fields that were read are returned
+// along with the errors so they can be validated too
+func parseSchema(schema []byte) (Schema, error) {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(schema, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %v", jsonError(err))
 	}
 
-	var parsedFields []Field
-	for _, field := range fields {
-		reflectedField := reflect.ValueOf(field).MapIndex(reflect.ValueOf("field"))
-		reflectedSource := reflect.ValueOf(field).MapIndex(reflect.ValueOf("source"))
-		j, err := json.Marshal(field)
+	var parsedFields Schema
+	var errs []error
+	for i, raw := range fields {
+		parsed, err := parseField(i, raw)
 		if err != nil {
-			log.Fatalf("failed to stringify field '%s'\n", reflectedField)
+			errs = append(errs, err)
 		}
-
-		switch reflectedSource.Interface() {
-		case "randomBool":
-			parsedFields = append(parsedFields, ParseField[RandomBoolField](j))
-		case "randomAscii":
-			parsedFields = append(parsedFields, ParseField[RandomASCIIField](j))
-		case "randomUniformInt":
-			parsedFields = append(parsedFields, ParseField[RandomUniformIntField](j))
-		case "randomNormal":
-			parsedFields = append(parsedFields, ParseField[RandomNormalField](j))
-		case "randomPoisson":
-			parsedFields = append(parsedFields, ParseField[RandomPoissonField](j))
-		case "randomEnum":
-			parsedFields = append(parsedFields, ParseField[RandomEnumField](j))
-		case "firstNames":
-			parsedFields = append(parsedFields, ParseField[FirstNameField](j))
-		case "lastNames":
-			parsedFields = append(parsedFields, ParseField[LastNameField](j))
-		case "randomDatetime":
-			parsedFields = append(parsedFields, ParseField[RandomDatetimeField](j))
-		case "randomTime":
-			parsedFields = append(parsedFields, ParseField[RandomTimeField](j))
-		case "randomDate":
-			parsedFields = append(parsedFields, ParseField[RandomDateField](j))
-		case "uuid":
-			parsedFields = append(parsedFields, ParseField[UUIDField](j))
-		case "emails":
-			parsedFields = append(parsedFields, ParseField[EmailField](j))
-		case "companies":
-			parsedFields = append(parsedFields, ParseField[CompanyField](j))
-		case "derived":
-			parsedFields = append(parsedFields, ParseField[DerivedField](j))
+		if parsed == nil {
+			continue
+		}
+		if slices.ContainsFunc(parsedFields, func(f Field) bool { return f.GetName() == parsed.GetName() }) {
+			errs = append(errs, fmt.Errorf("duplicate field %s", parsed.GetName()))
+			continue
 		}
+		parsedFields = append(parsedFields, parsed)
+	}
+	return parsedFields, errors.Join(errs...)
+}
+
+// parseField reads the i-th field of a schema with its source, unique and
+// faults. A named field that can't be read is an invalidField so fields
+// reading it aren't reported as reading an unknown field
+func parseField(i int, raw []byte) (Field, error) {
+	var field rawField
+	_ = json.Unmarshal(raw, &field)
+	if field.Field == "" {
+		return nil, fmt.Errorf("expected a name in field %d, e.g. {\"field\": \"id\", \"source\": \"uuid\"}", i+1)
+	}
+	invalid := &invalidField{name: field.Field}
+
+	var errs []error
+	if err := decodeStrict(raw, &field); err != nil {
+		errs = append(errs, fmt.Errorf("invalid field %s: %v", field.Field, err))
+	}
+	if !slices.Contains(SOURCES, field.Source) {
+		errs = append(errs, fmt.Errorf("unsupported source `%s` for field %s, expected one of %v", field.Source, field.Field, SOURCES))
+		return invalid, errors.Join(errs...)
+	}
+	if err := requireConfig(field); err != nil {
+		errs = append(errs, err)
 	}
 
-	return parsedFields
+	// the source only reads its name and config, the keys of every field are
+	// read by the wrappers. An empty config is left out for sources without
+	// one
+	config := field.Config
+	if c := bytes.TrimSpace(config); bytes.Equal(c, []byte("{}")) || bytes.Equal(c, []byte("null")) {
+		config = nil
+	}
+	j, err := json.Marshal(rawSource{Field: field.Field, Source: field.Source, Config: config})
+	if err != nil {
+		return invalid, fmt.Errorf("failed to stringify field %s: %v", field.Field, err)
+	}
+	parsed, err := parseSource(field.Source, j)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("invalid config of field %s: %v", field.Field, err))
+		return invalid, errors.Join(errs...)
+	}
+
+	if parsed, err = withUnique(parsed, field.Unique, field.Faults); err != nil {
+		errs = append(errs, err)
+		return invalid, errors.Join(errs...)
+	}
+	if parsed, err = withFaults(parsed, field.Faults); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return invalid, errors.Join(errs...)
+	}
+	return parsed, nil
+}
+
+func parseSource(source string, j []byte) (Field, error) {
+	switch source {
+	case "randomBool":
+		return ParseField[RandomBoolField](j)
+	case "randomAscii":
+		return ParseField[RandomASCIIField](j)
+	case "randomUniformInt":
+		return ParseField[RandomUniformIntField](j)
+	case "randomNormal":
+		return ParseField[RandomNormalField](j)
+	case "randomPoisson":
+		return ParseField[RandomPoissonField](j)
+	case "randomEnum":
+		return ParseField[RandomEnumField](j)
+	case "firstNames":
+		return ParseField[FirstNameField](j)
+	case "lastNames":
+		return ParseField[LastNameField](j)
+	case "randomDatetime":
+		return ParseField[RandomDatetimeField](j)
+	case "randomTime":
+		return ParseField[RandomTimeField](j)
+	case "randomDate":
+		return ParseField[RandomDateField](j)
+	case "uuid":
+		return ParseField[UUIDField](j)
+	case "emails":
+		return ParseField[EmailField](j)
+	case "companies":
+		return ParseField[CompanyField](j)
+	case "derived":
+		return ParseField[DerivedField](j)
+	case "ref":
+		return ParseField[RefField](j)
+	case "sequence":
+		return ParseField[SequenceField](j)
+	case "monotonicDatetime":
+		return ParseField[MonotonicDatetimeField](j)
+	case "randomUniformFloat":
+		return ParseField[RandomUniformFloatField](j)
+	case "randomLognormal":
+		return ParseField[RandomLognormalField](j)
+	case "randomExponential":
+		return ParseField[RandomExponentialField](j)
+	case "randomBeta":
+		return ParseField[RandomBetaField](j)
+	case "randomDecimal":
+		return ParseField[RandomDecimalField](j)
+	case "randomRegex":
+		return ParseField[RandomRegexField](j)
+	case "randomWords":
+		return ParseField[RandomWordsField](j)
+	case "randomFromFile":
+		return ParseField[RandomFromFileField](j)
+	default:
+		return nil, fmt.Errorf("unsupported source `%s`, expected one of %v", source, SOURCES)
+	}
 }
 
 type Field interface {
 	Generate(context.Context) any
 	GetName() string
+	Type(context.Context) LogicalType
+}
+
+// preparer is a field that reads or checks its config once before the
+// first value e.g. parses its bounds, so rows can be generated on workers
+// without setting up the field again for each value
+type preparer interface {
+	prepare(context.Context) error
+}
+
+func prepareField(ctx context.Context, f Field) error {
+	if p, ok := f.(preparer); ok {
+		return p.prepare(ctx)
+	}
+	return nil
+}
+
+// sequential is whether a field of the schema depends on the rows before it
+// e.g. unique values or duplicates of earlier ones, the rows of such a
+// schema are generated in order on one worker
+func sequential(schema Schema) bool {
+	for _, f := range schema {
+		for {
+			switch field := f.(type) {
+			case *MonotonicDatetimeField, *UniqueField, *recordedField:
+				return true
+			case *FaultyField:
+				if field.Faults.DuplicateRate > 0 {
+					return true
+				}
+				f = field.Field
+				continue
+			}
+			break
+		}
+	}
+	return false
+}
+
+// random is the seeded source every field draws from
+func random(ctx context.Context) *rand.Rand {
+	r, ok := ctx.Value(RAND_KEY).(*rand.Rand)
+	if !ok {
+		log.Fatalln("failed to read random source from context")
+	}
+	return r
+}
+
+// randReader reads random bytes from a seeded source, for uuids
+type randReader struct {
+	r *rand.Rand
+}
+
+func (rr randReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(rr.r.Uint32())
+	}
+	return len(p), nil
 }
 
 type RandomBoolField struct {
@@ -107,14 +265,15 @@ type RandomBoolField struct {
 
 // Generate randomly generated ascii string with chars from 33-126
 func (s RandomBoolField) Generate(ctx context.Context) any {
+	r := random(ctx)
 	var value bool
-	if rand.Float32() > 0.5 {
+	if r.Float32() > 0.5 {
 		value = true
 	} else {
 		value = false
 	}
 
-	cache.PutValue(s.Field, value)
+	putValue(ctx, s.Field, value)
 	return value
 }
 
@@ -122,25 +281,145 @@ func (s RandomBoolField) GetName() string {
 	return s.Field
 }
 
+func (s RandomBoolField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: BOOL_TYPE}
+}
+
 type RandomEnumField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
 	Config struct {
-		Values []string `json:"values"`
+		Values       []string  `json:"values"`
+		Weights      []float64 `json:"weights"`
+		Distribution string    `json:"distribution"`
+		S            float64   `json:"s"`
+		File         string    `json:"file"`
 	} `json:"config"`
+
+	values     []string
+	cumulative []float64
 }
 
-func (s RandomEnumField) Generate(ctx context.Context) any {
-	n := len(s.Config.Values)
-	value := s.Config.Values[rand.IntN(n)]
-	cache.PutValue(s.Field, value)
+// Generate picks one of the values, uniformly unless weights or a zipf
+// distribution skew it towards some
+func (s *RandomEnumField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	var value string
+	if s.cumulative == nil {
+		value = s.values[r.IntN(len(s.values))]
+	} else {
+		// the first value whose range of the total ends past the draw
+		x := r.Float64() * s.cumulative[len(s.cumulative)-1]
+		value = s.values[sort.Search(len(s.cumulative), func(i int) bool { return s.cumulative[i] > x })]
+	}
+
+	putValue(ctx, s.Field, value)
 	return value
 }
 
-func (s RandomEnumField) GetName() string {
+// prepare reads the values and their weights, from a csv of values and
+// optional weights when a file is given
+func (s *RandomEnumField) prepare(ctx context.Context) error {
+	values, weights := s.Config.Values, s.Config.Weights
+	if s.Config.File != "" {
+		if len(values) > 0 || len(weights) > 0 {
+			return fmt.Errorf("expected either values or a file for field %s", s.Field)
+		}
+
+		var err error
+		values, weights, err = readCategories(s.Config.File)
+		if err != nil {
+			return fmt.Errorf("failed to read values of field %s: %v", s.Field, err)
+		}
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("expected values for field %s", s.Field)
+	}
+
+	switch s.Config.Distribution {
+	case "":
+	case ZIPF_DISTRIBUTION:
+		if len(weights) > 0 {
+			return fmt.Errorf("expected either weights or a zipf distribution for field %s", s.Field)
+		}
+		exponent := s.Config.S
+		if exponent == 0 {
+			exponent = 1
+		}
+		if exponent < 0 {
+			return fmt.Errorf("expected a zipf exponent s above 0 for field %s", s.Field)
+		}
+		// the first value is the most common, the nth is 1/n^s as common
+		for rank := range values {
+			weights = append(weights, 1/math.Pow(float64(rank+1), exponent))
+		}
+	default:
+		return fmt.Errorf("unsupported distribution `%s` for field %s, expected %s", s.Config.Distribution, s.Field, ZIPF_DISTRIBUTION)
+	}
+
+	s.values = values
+	if len(weights) == 0 {
+		return nil
+	}
+	if len(weights) != len(values) {
+		return fmt.Errorf("expected a weight for each of the %d values of field %s, got %d", len(values), s.Field, len(weights))
+	}
+
+	var total float64
+	for _, w := range weights {
+		if w < 0 || math.IsNaN(w) || math.IsInf(w, 0) {
+			return fmt.Errorf("expected weights of field %s to be positive numbers: %g", s.Field, w)
+		}
+		total += w
+		s.cumulative = append(s.cumulative, total)
+	}
+	if total == 0 {
+		return fmt.Errorf("expected a weight above 0 for field %s", s.Field)
+	}
+	return nil
+}
+
+// readCategories reads the first column of a csv with a header as values and
+// the second, when there is one, as their weights
+func readCategories(file string) ([]string, []float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) < 2 {
+		return nil, nil, fmt.Errorf("expected a header and at least one value in %s", file)
+	}
+
+	var values []string
+	var weights []float64
+	for i, record := range records[1:] {
+		values = append(values, record[0])
+		if len(record) < 2 {
+			continue
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expected a number as weight on line %d of %s: %s", i+2, file, record[1])
+		}
+		weights = append(weights, w)
+	}
+	return values, weights, nil
+}
+
+func (s *RandomEnumField) GetName() string {
 	return s.Field
 }
 
+func (s *RandomEnumField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
 type RandomASCIIField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
@@ -149,14 +428,22 @@ type RandomASCIIField struct {
 	} `json:"config"`
 }
 
+func (s RandomASCIIField) prepare(ctx context.Context) error {
+	if s.Config.Length < 0 {
+		return fmt.Errorf("invalid length for field %s, expected a number of at least 0, not %d", s.Field, s.Config.Length)
+	}
+	return nil
+}
+
 // Generate randomly generated ascii string with chars from 33-126
 func (s RandomASCIIField) Generate(ctx context.Context) any {
+	r := random(ctx)
 	var value string
 	for range s.Config.Length {
-		value += string(uint8(rand.IntN(93) + 33))
+		value += string(uint8(r.IntN(93) + 33))
 	}
 
-	cache.PutValue(s.Field, value)
+	putValue(ctx, s.Field, value)
 
 	return value
 }
@@ -165,6 +452,10 @@ func (s RandomASCIIField) GetName() string {
 	return s.Field
 }
 
+func (s RandomASCIIField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
 type RandomUniformIntField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
@@ -174,9 +465,17 @@ type RandomUniformIntField struct {
 	} `json:"config"`
 }
 
+func (s RandomUniformIntField) prepare(ctx context.Context) error {
+	if s.Config.Min >= s.Config.Max {
+		return fmt.Errorf("invalid min and max for field %s, expected min below max as max is excluded, not %d and %d", s.Field, s.Config.Min, s.Config.Max)
+	}
+	return nil
+}
+
 func (s RandomUniformIntField) Generate(ctx context.Context) any {
-	value := rand.IntN(s.Config.Max-s.Config.Min) + s.Config.Min
-	cache.PutValue(s.Field, value)
+	r := random(ctx)
+	value := r.IntN(s.Config.Max-s.Config.Min) + s.Config.Min
+	putValue(ctx, s.Field, value)
 	return value
 }
 
@@ -184,18 +483,31 @@ func (s RandomUniformIntField) GetName() string {
 	return s.Field
 }
 
+func (s RandomUniformIntField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: INT_TYPE}
+}
+
 type RandomNormalField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
 	Config struct {
 		Mean float64 `json:"mean"`
 		Std  float64 `json:"std"`
+		Rounding
 	} `json:"config"`
 }
 
+func (s RandomNormalField) prepare(ctx context.Context) error {
+	if s.Config.Std < 0 {
+		return fmt.Errorf("invalid std for field %s, expected a number of at least 0, not %v", s.Field, s.Config.Std)
+	}
+	return nil
+}
+
 func (s RandomNormalField) Generate(ctx context.Context) any {
-	value := rand.NormFloat64()*s.Config.Std + s.Config.Mean
-	cache.PutValue(s.Field, value)
+	r := random(ctx)
+	value := s.Config.round(r.NormFloat64()*s.Config.Std + s.Config.Mean)
+	putValue(ctx, s.Field, value)
 	return value
 }
 
@@ -203,6 +515,10 @@ func (s RandomNormalField) GetName() string {
 	return s.Field
 }
 
+func (s RandomNormalField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: FLOAT_TYPE}
+}
+
 type RandomPoissonField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
@@ -211,17 +527,24 @@ type RandomPoissonField struct {
 	} `json:"config"`
 }
 
+func (s RandomPoissonField) prepare(ctx context.Context) error {
+	if s.Config.Lambda < 1 {
+		return fmt.Errorf("invalid lambda for field %s, expected a number of at least 1, not %d", s.Field, s.Config.Lambda)
+	}
+	return nil
+}
+
 func (s RandomPoissonField) Generate(ctx context.Context) any {
-	value := strconv.Itoa(generatePoisson(s.Config.Lambda))
-	cache.PutValue(s.Field, value)
+	value := generatePoisson(random(ctx), s.Config.Lambda)
+	putValue(ctx, s.Field, value)
 	return value
 }
 
-func generatePoisson(lambda int) int {
+func generatePoisson(r *rand.Rand, lambda int) int {
 	var n int
 
 	for s := 0.0; s < 1; {
-		u := rand.Float64()
+		u := r.Float64()
 		e := -math.Log(u) / float64(lambda)
 		n += 1
 		s += e
@@ -234,14 +557,19 @@ func (s RandomPoissonField) GetName() string {
 	return s.Field
 }
 
+func (s RandomPoissonField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: INT_TYPE}
+}
+
 type LastNameField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
 }
 
 func (s LastNameField) Generate(ctx context.Context) any {
-	value := sources.LastNames[rand.IntN(len(sources.LastNames))]
-	cache.PutValue(s.Field, value)
+	r := random(ctx)
+	value := sources.LastNames[r.IntN(len(sources.LastNames))]
+	putValue(ctx, s.Field, value)
 	return value
 }
 
@@ -249,14 +577,19 @@ func (s LastNameField) GetName() string {
 	return s.Field
 }
 
+func (s LastNameField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
 type FirstNameField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
 }
 
 func (s FirstNameField) Generate(ctx context.Context) any {
-	value := sources.FirstNames[rand.IntN(len(sources.FirstNames))]
-	cache.PutValue(s.Field, value)
+	r := random(ctx)
+	value := sources.FirstNames[r.IntN(len(sources.FirstNames))]
+	putValue(ctx, s.Field, value)
 	return value
 }
 
@@ -264,6 +597,38 @@ func (s FirstNameField) GetName() string {
 	return s.Field
 }
 
+func (s FirstNameField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
+// dates are drawn from the epoch up to the latest time go can represent when
+// no min or max is set
+var (
+	MIN_TIME = time.Unix(0, 0)
+	MAX_TIME = time.Unix(1<<63-62135596801, 999999999)
+)
+
+// unixBounds are the seconds of min and max, parsed with layout in loc and
+// limited to lower and upper
+func unixBounds(layout string, minValue string, maxValue string, loc *time.Location, lower time.Time, upper time.Time) (int64, int64, error) {
+	lb, ub := lower.Unix(), upper.Unix()
+	if minValue != "" {
+		parsed, err := time.ParseInLocation(layout, minValue, loc)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse min: %v", err)
+		}
+		lb = max(lb, parsed.Unix())
+	}
+	if maxValue != "" {
+		parsed, err := time.ParseInLocation(layout, maxValue, loc)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to parse max: %v", err)
+		}
+		ub = min(ub, parsed.Unix())
+	}
+	return lb, ub, nil
+}
+
 type RandomDatetimeField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
@@ -272,58 +637,43 @@ type RandomDatetimeField struct {
 		Min string `json:"min"`
 		Max string `json:"max"`
 	} `json:"config"`
-}
 
-func (s RandomDatetimeField) Generate(ctx context.Context) any {
-	maxTime := time.Unix(1<<63-62135596801, 999999999)
-	minTime := time.Unix(0, 0)
+	loc    *time.Location
+	lb, ub int64
+}
 
-	loc, err := time.LoadLocation(s.Config.Tz)
+func (s *RandomDatetimeField) prepare(ctx context.Context) error {
+	var err error
+	s.loc, err = time.LoadLocation(s.Config.Tz)
 	if err != nil {
-		log.Fatalf("failed to parse tz: %v\n", err)
-	}
-
-	// handle min datetime
-	var parsedDtMin time.Time
-	if s.Config.Min != "" {
-		parsedDtMin, err = time.ParseInLocation(time.DateTime, s.Config.Min, loc)
-		if err != nil {
-			log.Fatalf("failed to parse max datetime: %v\n", err)
-		}
-	} else {
-		parsedDtMin = minTime
-	}
-
-	lb := minTime.Unix()
-	if parsedDtMin.After(minTime) {
-		lb = parsedDtMin.Unix()
+		return fmt.Errorf("failed to parse tz of field %s: %v", s.Field, err)
 	}
 
-	// handle max datetime
-	var parsedDtMax time.Time
-	if s.Config.Max != "" {
-		parsedDtMax, err = time.ParseInLocation(time.DateTime, s.Config.Max, loc)
-		if err != nil {
-			log.Fatalf("failed to parse max datetime: %v\n", err)
-		}
-	} else {
-		parsedDtMax = maxTime
+	s.lb, s.ub, err = unixBounds(time.DateTime, s.Config.Min, s.Config.Max, s.loc, MIN_TIME, MAX_TIME)
+	if err != nil {
+		return fmt.Errorf("invalid datetime for field %s: %v", s.Field, err)
 	}
-
-	ub := maxTime.Unix()
-	if parsedDtMax.Before(maxTime) {
-		ub = parsedDtMax.Unix()
+	if s.lb >= s.ub {
+		return fmt.Errorf("invalid min and max for field %s, expected min before max", s.Field)
 	}
+	return nil
+}
 
-	value := time.Unix(rand.Int64N(ub-lb)+lb, 0).In(loc).Format(time.RFC3339)
-	cache.PutValue(s.Field, value)
+func (s *RandomDatetimeField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := time.Unix(r.Int64N(s.ub-s.lb)+s.lb, 0).In(s.loc)
+	putValue(ctx, s.Field, value)
 	return value
 }
 
-func (s RandomDatetimeField) GetName() string {
+func (s *RandomDatetimeField) GetName() string {
 	return s.Field
 }
 
+func (s *RandomDatetimeField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: TIMESTAMP_TYPE}
+}
+
 type RandomDateField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
@@ -331,54 +681,37 @@ type RandomDateField struct {
 		Min string `json:"min"`
 		Max string `json:"max"`
 	} `json:"config"`
-}
 
-func (s RandomDateField) Generate(ctx context.Context) any {
-	maxTime := time.Unix(1<<63-62135596801, 999999999)
-	minTime := time.Unix(0, 0)
+	lb, ub int64
+}
 
-	// handle min date
+func (s *RandomDateField) prepare(ctx context.Context) error {
 	var err error
-	var parsedDtMin time.Time
-	if s.Config.Min != "" {
-		parsedDtMin, err = time.Parse(time.DateOnly, s.Config.Min)
-		if err != nil {
-			log.Fatalf("failed to parse max date: %v\n", err)
-		}
-	} else {
-		parsedDtMin = minTime
-	}
-
-	lb := minTime.Unix()
-	if parsedDtMin.After(minTime) {
-		lb = parsedDtMin.Unix()
-	}
-
-	// handle max date
-	var parsedDtMax time.Time
-	if s.Config.Max != "" {
-		parsedDtMax, err = time.Parse(time.DateOnly, s.Config.Max)
-		if err != nil {
-			log.Fatalf("failed to parse max date: %v\n", err)
-		}
-	} else {
-		parsedDtMax = maxTime
+	s.lb, s.ub, err = unixBounds(time.DateOnly, s.Config.Min, s.Config.Max, time.UTC, MIN_TIME, MAX_TIME)
+	if err != nil {
+		return fmt.Errorf("invalid date for field %s: %v", s.Field, err)
 	}
-
-	ub := maxTime.Unix()
-	if parsedDtMax.Before(maxTime) {
-		ub = parsedDtMax.Unix()
+	if s.lb >= s.ub {
+		return fmt.Errorf("invalid min and max for field %s, expected min before max", s.Field)
 	}
+	return nil
+}
 
-	value := time.Unix(rand.Int64N(ub-lb)+lb, 0).Format(time.DateOnly)
-	cache.PutValue(s.Field, value)
+func (s *RandomDateField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := time.Unix(r.Int64N(s.ub-s.lb)+s.lb, 0).UTC()
+	putValue(ctx, s.Field, value)
 	return value
 }
 
-func (s RandomDateField) GetName() string {
+func (s *RandomDateField) GetName() string {
 	return s.Field
 }
 
+func (s *RandomDateField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: DATE_TYPE}
+}
+
 type RandomTimeField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
@@ -386,68 +719,54 @@ type RandomTimeField struct {
 		Min string `json:"min"`
 		Max string `json:"max"`
 	} `json:"config"`
-}
 
-func (s RandomTimeField) Generate(ctx context.Context) any {
-	maxTime, _ := time.ParseInLocation(time.TimeOnly, "23:59:59", time.UTC)
-	minTime, _ := time.ParseInLocation(time.TimeOnly, "00:00:00", time.UTC)
+	lb, ub int64
+}
 
-	// handle min time
-	var err error
-	var parsedDtMin time.Time
-	if s.Config.Min != "" {
-		if len(s.Config.Min) < 8 {
-			log.Fatalf("invalid format for min must be HH:MM:SS, not %v\n", s.Config.Min)
+func (s *RandomTimeField) prepare(ctx context.Context) error {
+	for _, bound := range []string{s.Config.Min, s.Config.Max} {
+		if bound != "" && len(bound) < 8 {
+			return fmt.Errorf("invalid time for field %s, must be HH:MM:SS, not %v", s.Field, bound)
 		}
-		parsedDtMin, err = time.ParseInLocation(time.TimeOnly, s.Config.Min, time.UTC)
-		if err != nil {
-			log.Fatalf("failed to parse max time: %v\n", err)
-		}
-	} else {
-		parsedDtMin = minTime
 	}
 
-	lb := minTime.Unix()
-	if parsedDtMin.After(minTime) {
-		lb = parsedDtMin.Unix()
-	}
+	minTime, _ := time.ParseInLocation(time.TimeOnly, "00:00:00", time.UTC)
+	maxTime, _ := time.ParseInLocation(time.TimeOnly, "23:59:59", time.UTC)
 
-	// handle max time
-	var parsedDtMax time.Time
-	if s.Config.Max != "" {
-		if len(s.Config.Max) < 8 {
-			log.Fatalf("invalid format for max must be HH:MM:SS, not %v\n", s.Config.Max)
-		}
-		parsedDtMax, err = time.ParseInLocation(time.TimeOnly, s.Config.Max, time.UTC)
-		if err != nil {
-			log.Fatalf("failed to parse max time: %v\n", err)
-		}
-	} else {
-		parsedDtMax = maxTime
+	var err error
+	s.lb, s.ub, err = unixBounds(time.TimeOnly, s.Config.Min, s.Config.Max, time.UTC, minTime, maxTime)
+	if err != nil {
+		return fmt.Errorf("invalid time for field %s: %v", s.Field, err)
 	}
-
-	ub := maxTime.Unix()
-	if parsedDtMax.Before(maxTime) {
-		ub = parsedDtMax.Unix()
+	if s.lb >= s.ub {
+		return fmt.Errorf("invalid min and max for field %s, expected min before max", s.Field)
 	}
+	return nil
+}
 
-	value := time.Unix(rand.Int64N(ub-lb)+lb, 0).In(time.UTC).Format(time.TimeOnly)
-	cache.PutValue(s.Field, value)
+func (s *RandomTimeField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := time.Unix(r.Int64N(s.ub-s.lb)+s.lb, 0).In(time.UTC)
+	putValue(ctx, s.Field, value)
 	return value
 }
 
-func (s RandomTimeField) GetName() string {
+func (s *RandomTimeField) GetName() string {
 	return s.Field
 }
 
+func (s *RandomTimeField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: TIME_TYPE}
+}
+
 type UUIDField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
 }
 
 func (s UUIDField) Generate(ctx context.Context) any {
-	value := uuid.NewString()
-	cache.PutValue(s.Field, value)
+	value := uuid.Must(uuid.NewRandomFromReader(randReader{random(ctx)})).String()
+	putValue(ctx, s.Field, value)
 	return value
 }
 
@@ -455,14 +774,23 @@ func (s UUIDField) GetName() string {
 	return s.Field
 }
 
+func (s UUIDField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
 type EmailField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
 }
 
 func (s EmailField) Generate(ctx context.Context) any {
-	value := sources.Emails[rand.IntN(len(sources.Emails))]
-	cache.PutValue(s.Field, value)
+	r := random(ctx)
+	emails, ok := ctx.Value(EMAILS_KEY).([]string)
+	if !ok {
+		log.Fatalln("failed to read emails from context")
+	}
+	value := emails[r.IntN(len(emails))]
+	putValue(ctx, s.Field, value)
 	return value
 }
 
@@ -470,17 +798,116 @@ func (s EmailField) GetName() string {
 	return s.Field
 }
 
+func (s EmailField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
 type CompanyField struct {
 	Field  string `json:"field"`
 	Source string `json:"source"`
 }
 
 func (s CompanyField) Generate(ctx context.Context) any {
-	value := sources.Companies[rand.IntN(len(sources.Companies))]
-	cache.PutValue(s.Field, value)
+	r := random(ctx)
+	value := sources.Companies[r.IntN(len(sources.Companies))]
+	putValue(ctx, s.Field, value)
 	return value
 }
 
 func (s CompanyField) GetName() string {
 	return s.Field
 }
+
+func (s CompanyField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
+type SequenceField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Start int  `json:"start"`
+		Step  *int `json:"step"`
+	} `json:"config"`
+}
+
+// Generate counts from start by step, 1 when not set, the value of a row
+// is its number so rows can be generated in any order
+func (s *SequenceField) Generate(ctx context.Context) any {
+	step := 1
+	if s.Config.Step != nil {
+		step = *s.Config.Step
+	}
+
+	value := s.Config.Start + rowIndex(ctx)*step
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func (s *SequenceField) GetName() string {
+	return s.Field
+}
+
+func (s *SequenceField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: INT_TYPE}
+}
+
+type MonotonicDatetimeField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Tz     string `json:"tz"`
+		Start  string `json:"start"`
+		Jitter string `json:"jitter"`
+	} `json:"config"`
+
+	current time.Time
+	jitter  time.Duration
+	started bool
+}
+
+func (s *MonotonicDatetimeField) prepare(ctx context.Context) error {
+	loc, err := time.LoadLocation(s.Config.Tz)
+	if err != nil {
+		return fmt.Errorf("failed to parse tz of field %s: %v", s.Field, err)
+	}
+
+	s.current = time.Unix(0, 0).In(loc)
+	if s.Config.Start != "" {
+		s.current, err = time.ParseInLocation(time.DateTime, s.Config.Start, loc)
+		if err != nil {
+			return fmt.Errorf("failed to parse start of field %s: %v", s.Field, err)
+		}
+	}
+
+	s.jitter = time.Minute
+	if s.Config.Jitter != "" {
+		s.jitter, err = time.ParseDuration(s.Config.Jitter)
+		if err != nil || s.jitter < time.Second {
+			return fmt.Errorf("invalid jitter for field %s, expected a duration of at least 1s e.g. 90s or 1h, not %v", s.Field, s.Config.Jitter)
+		}
+	}
+	s.started = false
+	return nil
+}
+
+// Generate starts at start and moves forward by a random whole number of
+// seconds between 1 and jitter, 1m when not set, so values strictly increase
+func (s *MonotonicDatetimeField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	if s.started {
+		s.current = s.current.Add(time.Duration(r.Int64N(int64(s.jitter/time.Second))+1) * time.Second)
+	}
+	s.started = true
+
+	putValue(ctx, s.Field, s.current)
+	return s.current
+}
+
+func (s *MonotonicDatetimeField) GetName() string {
+	return s.Field
+}
+
+func (s *MonotonicDatetimeField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: TIMESTAMP_TYPE}
+}
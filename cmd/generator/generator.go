@@ -2,11 +2,15 @@ package generator
 
 import (
 	"context"
-	"io"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand/v2"
 	"os"
-	"reflect"
+	"runtime"
+	"slices"
 
+	"dct/cmd/generator/sources"
 	"dct/cmd/utils"
 
 	"github.com/spf13/cobra"
@@ -17,13 +21,25 @@ var (
 	lines     int
 	format    string
 	outfile   string
-	cache     utils.Cache = utils.NewCache()
+	seed      uint64
+	ddl       bool
+	table     string
+	options   WriteOptions
+	check     bool
 )
 
 func init() {
 	GenCmd.Flags().StringVarP(&outfile, "outfile", "o", "", "Output file path (default: stdout)")
-	GenCmd.Flags().StringVarP(&format, "format", "f", "csv", "Output format supports ndjson, csv")
+	GenCmd.Flags().StringVarP(&format, "format", "f", "csv", "Output format supports csv, ndjson, json, parquet")
 	GenCmd.Flags().IntVarP(&lines, "lines", "n", 1, "Number of data rows to generate")
+	GenCmd.Flags().Uint64VarP(&seed, "seed", "s", 0, "Seed for reproducible output (default: random)")
+	GenCmd.Flags().StringVar(&options.Parquet.Compression, "compression", "snappy", "Parquet compression supports snappy, gzip, zstd, lz4, brotli, uncompressed")
+	GenCmd.Flags().IntVar(&options.Parquet.RowGroupSize, "row-group-size", PARQUET_ROW_GROUP_SIZE, "Rows per parquet row group")
+	GenCmd.Flags().BoolVar(&ddl, "ddl", false, "Write the create table statement matching the generated data instead")
+	GenCmd.Flags().StringVarP(&table, "table", "t", "default", "Table name used in the create table statement")
+	GenCmd.Flags().BoolVar(&check, "validate", false, "Check the schema and report every problem in it without generating data")
+	GenCmd.Flags().IntVarP(&options.Workers, "workers", "w", 0, "Number of workers generating rows (default: number of cpus)")
+	GenCmd.Flags().IntVar(&options.RowsPerFile, "rows-per-file", 0, "Split the output into numbered files of this many rows (default: one file)")
 }
 
 type (
@@ -36,36 +52,136 @@ const (
 	FORMAT_KEY    ctxKey = "format"
 	SCHEMA_KEY    ctxKey = "schema"
 	FIELD_MAP_KEY ctxKey = "fieldMap"
+	RAND_KEY      ctxKey = "rand"
+	ROW_KEY       ctxKey = "row"
+	EMAILS_KEY    ctxKey = "emails"
+
+	// duckdb's default
+	PARQUET_ROW_GROUP_SIZE = 122_880
 )
 
+var PARQUET_COMPRESSIONS = []string{"snappy", "gzip", "zstd", "lz4", "brotli", "uncompressed"}
+
 var GenCmd = &cobra.Command{
 	Use:   "gen [schema]",
 	Short: "Generate synthetic data",
 	Long:  `Create realistic test data based on a schema definition with support for custom field types and derived fields`,
 	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
 	Run: func(cmd *cobra.Command, args []string) {
-		var out io.Writer
-		var err error
-		if outfile != "" {
-			out, err = os.Create(outfile)
-			if err != nil {
-				log.Fatalf("failed to create out file: %v\n", err)
+		ext := "." + format
+		if !slices.Contains(utils.GENERATOR_OUTPUT_FILETYPES, ext) {
+			log.Fatalf("Error: unsupported format: %s, expected one of %v\n", format, utils.GENERATOR_OUTPUT_FILETYPES)
+		}
+		if ext == utils.PARQUET && !ddl && !check {
+			if outfile == "" {
+				log.Fatalf("Error: parquet can't be written to stdout, expected --outfile\n")
+			}
+			if !slices.Contains(PARQUET_COMPRESSIONS, options.Parquet.Compression) {
+				log.Fatalf("Error: unsupported compression: %s, expected one of %v\n", options.Parquet.Compression, PARQUET_COMPRESSIONS)
+			}
+			if options.Parquet.RowGroupSize < 1 {
+				log.Fatalf("Error: expected --row-group-size to be at least 1\n")
 			}
-		} else {
-			out = os.Stdout
 		}
 
-		rawSchema = args[0]
-		fieldMap := make(FieldMap)
-		schema := parseSchema(rawSchema)
-		for i, f := range schema {
-			fieldMap[reflect.ValueOf(f).Elem().FieldByName("Field").String()] = i
+		if options.Workers < 0 {
+			log.Fatalf("Error: expected --workers to be at least 1\n")
+		}
+		if options.Workers == 0 {
+			options.Workers = runtime.NumCPU()
+		}
+		if options.RowsPerFile < 0 {
+			log.Fatalf("Error: expected --rows-per-file to be at least 1\n")
 		}
+		if options.RowsPerFile > 0 && outfile == "" && !ddl && !check {
+			log.Fatalf("Error: files of --rows-per-file are named after --outfile, expected --outfile\n")
+		}
+
+		// every random value is drawn from one seeded source so a seed
+		// reproduces the output byte for byte
+		if !cmd.Flags().Changed("seed") {
+			seed = rand.Uint64()
+		}
+		r := rand.New(rand.NewPCG(seed, seed))
 
 		ctx := context.Background()
-		ctx = context.WithValue(ctx, FORMAT_KEY, "."+format)
-		ctx = context.WithValue(ctx, SCHEMA_KEY, schema)
-		ctx = context.WithValue(ctx, FIELD_MAP_KEY, fieldMap)
-		Write(ctx, out, lines)
+		ctx = context.WithValue(ctx, FORMAT_KEY, ext)
+		ctx = context.WithValue(ctx, RAND_KEY, r)
+		ctx = context.WithValue(ctx, EMAILS_KEY, sources.NewEmails(r))
+
+		rawSchema = args[0]
+		raw := readSchema(rawSchema)
+		if isTables(raw) {
+			generateTables(ctx, raw)
+			return
+		}
+
+		schema, err := parseSchema(raw)
+		if len(refs(schema)) > 0 {
+			log.Fatalf("Error: ref fields reference other tables, expected a schema of tables\n")
+		}
+		ctx = schemaContext(ctx, schema)
+
+		// every run validates the whole schema first so every problem in it
+		// is reported at once, fields that can't be read are validated too
+		if err := errors.Join(err, validate(ctx, schema)); err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
+		if check {
+			fmt.Println("schema is valid")
+			return
+		}
+
+		if ddl {
+			writeDDL(DDL(ctx, schema, table, nil))
+			return
+		}
+
+		if err := Write(ctx, outfile, lines, options); err != nil {
+			log.Fatalf("Error: %v\n", err)
+		}
 	},
 }
+
+// writeDDL writes create table statements to --outfile or stdout
+func writeDDL(statements string) {
+	out := os.Stdout
+	if outfile != "" {
+		var err error
+		out, err = os.Create(outfile)
+		if err != nil {
+			log.Fatalf("failed to create out file: %v\n", err)
+		}
+		defer func() { _ = out.Close() }()
+	}
+	_, _ = fmt.Fprintln(out, statements)
+}
+
+// generateTables writes a schema of several tables to a directory of one file
+// per table, or the create table statements of them all with --ddl
+func generateTables(ctx context.Context, raw []byte) {
+	tables, err := parseTables(raw)
+	if tables == nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+	if err := errors.Join(err, validateTables(ctx, tables)); err != nil {
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	if check {
+		fmt.Println("schema is valid")
+		return
+	}
+
+	if ddl {
+		writeDDL(TablesDDL(ctx, tables))
+		return
+	}
+
+	if outfile == "" {
+		log.Fatalf("Error: a schema of tables is written to a file per table, expected --outfile directory\n")
+	}
+	if err := WriteTables(ctx, outfile, tables, lines, options); err != nil {
+		log.Fatalf("failed to write tables: %v\n", err)
+	}
+}
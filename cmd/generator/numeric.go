@@ -0,0 +1,244 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// a double holds 15 significant digits exactly, decimals are generated as
+// doubles so can't have more
+const MAX_DECIMAL_PRECISION = 15
+
+// Rounding is the config shared by float sources, digits are significant
+// digits and decimals are digits after the point, both when set
+type Rounding struct {
+	Digits   int  `json:"digits"`
+	Decimals *int `json:"decimals"`
+}
+
+func (c Rounding) round(v float64) float64 {
+	if c.Digits > 0 && v != 0 && !math.IsInf(v, 0) && !math.IsNaN(v) {
+		exp := math.Floor(math.Log10(math.Abs(v)))
+		factor := math.Pow(10, float64(c.Digits-1)-exp)
+		v = math.Round(v*factor) / factor
+	}
+	if c.Decimals != nil {
+		factor := math.Pow(10, float64(*c.Decimals))
+		v = math.Round(v*factor) / factor
+	}
+	return v
+}
+
+type RandomUniformFloatField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Min float64 `json:"min"`
+		Max float64 `json:"max"`
+		Rounding
+	} `json:"config"`
+}
+
+func (s RandomUniformFloatField) prepare(ctx context.Context) error {
+	if s.Config.Min > s.Config.Max {
+		return fmt.Errorf("invalid min and max for field %s, expected min of at most max, not %v and %v", s.Field, s.Config.Min, s.Config.Max)
+	}
+	return nil
+}
+
+func (s RandomUniformFloatField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := s.Config.round(r.Float64()*(s.Config.Max-s.Config.Min) + s.Config.Min)
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func (s RandomUniformFloatField) GetName() string {
+	return s.Field
+}
+
+func (s RandomUniformFloatField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: FLOAT_TYPE}
+}
+
+// RandomLognormalField is a value whose log is normal with mean mu and
+// standard deviation sigma, e.g. incomes or response times
+type RandomLognormalField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Mu    float64 `json:"mu"`
+		Sigma float64 `json:"sigma"`
+		Rounding
+	} `json:"config"`
+}
+
+func (s RandomLognormalField) prepare(ctx context.Context) error {
+	if s.Config.Sigma < 0 {
+		return fmt.Errorf("invalid sigma for field %s, expected a number of at least 0, not %v", s.Field, s.Config.Sigma)
+	}
+	return nil
+}
+
+func (s RandomLognormalField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := s.Config.round(math.Exp(r.NormFloat64()*s.Config.Sigma + s.Config.Mu))
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func (s RandomLognormalField) GetName() string {
+	return s.Field
+}
+
+func (s RandomLognormalField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: FLOAT_TYPE}
+}
+
+// RandomExponentialField is the time between events that happen at rate per
+// unit of time, the mean is 1/rate
+type RandomExponentialField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Rate float64 `json:"rate"`
+		Rounding
+	} `json:"config"`
+}
+
+func (s RandomExponentialField) prepare(ctx context.Context) error {
+	if s.Config.Rate <= 0 {
+		return fmt.Errorf("invalid rate for field %s, expected a number above 0, not %v", s.Field, s.Config.Rate)
+	}
+	return nil
+}
+
+func (s RandomExponentialField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := s.Config.round(r.ExpFloat64() / s.Config.Rate)
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func (s RandomExponentialField) GetName() string {
+	return s.Field
+}
+
+func (s RandomExponentialField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: FLOAT_TYPE}
+}
+
+// RandomBetaField is a value between 0 and 1 e.g. a rate or a share, shaped
+// by alpha and beta
+type RandomBetaField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Alpha float64 `json:"alpha"`
+		Beta  float64 `json:"beta"`
+		Rounding
+	} `json:"config"`
+}
+
+func (s RandomBetaField) prepare(ctx context.Context) error {
+	if s.Config.Alpha <= 0 || s.Config.Beta <= 0 {
+		return fmt.Errorf("invalid alpha or beta for field %s, expected numbers above 0", s.Field)
+	}
+	return nil
+}
+
+func (s RandomBetaField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	x := generateGamma(r, s.Config.Alpha)
+	y := generateGamma(r, s.Config.Beta)
+	value := s.Config.round(x / (x + y))
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+// generateGamma draws from a gamma distribution with a scale of 1 using
+// Marsaglia and Tsang's method
+func generateGamma(r *rand.Rand, shape float64) float64 {
+	// shapes below 1 are drawn for shape+1 and scaled back
+	if shape < 1 {
+		return generateGamma(r, shape+1) * math.Pow(r.Float64(), 1/shape)
+	}
+
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := r.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := r.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+func (s RandomBetaField) GetName() string {
+	return s.Field
+}
+
+func (s RandomBetaField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: FLOAT_TYPE}
+}
+
+// RandomDecimalField is a uniform decimal(precision, scale) between min and
+// max, the whole range of the type when not set
+type RandomDecimalField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Precision int      `json:"precision"`
+		Scale     int      `json:"scale"`
+		Min       *float64 `json:"min"`
+		Max       *float64 `json:"max"`
+	} `json:"config"`
+
+	unit   float64
+	lb, ub float64
+}
+
+func (s *RandomDecimalField) prepare(ctx context.Context) error {
+	p, sc := s.Config.Precision, s.Config.Scale
+	if p < 1 || p > MAX_DECIMAL_PRECISION || sc < 0 || sc > p {
+		return fmt.Errorf("invalid precision or scale for field %s, expected 0 <= scale <= precision <= %d", s.Field, MAX_DECIMAL_PRECISION)
+	}
+
+	// drawn as a whole number of the smallest unit so every value is exact
+	s.unit = math.Pow(10, float64(sc))
+	largest := math.Pow(10, float64(p)) - 1
+	s.lb, s.ub = -largest, largest
+	if s.Config.Min != nil {
+		s.lb = max(s.lb, math.Ceil(*s.Config.Min*s.unit))
+	}
+	if s.Config.Max != nil {
+		s.ub = min(s.ub, math.Floor(*s.Config.Max*s.unit))
+	}
+	if s.lb > s.ub {
+		return fmt.Errorf("invalid min and max for field %s, expected a decimal(%d,%d) between them", s.Field, p, sc)
+	}
+	return nil
+}
+
+func (s *RandomDecimalField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := (float64(r.Int64N(int64(s.ub-s.lb)+1)) + s.lb) / s.unit
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func (s *RandomDecimalField) GetName() string {
+	return s.Field
+}
+
+func (s *RandomDecimalField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: DECIMAL_TYPE, Precision: s.Config.Precision, Scale: s.Config.Scale}
+}
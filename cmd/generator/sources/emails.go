@@ -6,18 +6,23 @@ import (
 	"strings"
 )
 
-var Emails [200]string
+const EMAILS = 200
 
-func init() {
+// NewEmails builds a pool of emails from names and companies, the same
+// random source gives the same pool
+func NewEmails(r *rand.Rand) []string {
 	emailSanitiser := strings.NewReplacer(" ", "", "@", "")
 
-	for i := range 200 {
-		firstNameIdx := rand.IntN(len(FirstNames))
+	emails := make([]string, EMAILS)
+	for i := range EMAILS {
+		firstNameIdx := r.IntN(len(FirstNames))
 		firstName := FirstNames[firstNameIdx]
-		lastNameIdx := rand.IntN(len(LastNames))
+		lastNameIdx := r.IntN(len(LastNames))
 		lastName := LastNames[lastNameIdx]
-		companiesIdx := rand.IntN(len(Companies))
+		companiesIdx := r.IntN(len(Companies))
 		company := Companies[companiesIdx]
-		Emails[i] = fmt.Sprintf("%s.%s@%s.com", firstName, lastName, emailSanitiser.Replace(company))
+		emails[i] = fmt.Sprintf("%s.%s@%s.com", firstName, lastName, emailSanitiser.Replace(company))
 	}
+
+	return emails
 }
@@ -0,0 +1,244 @@
+package generator
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+
+	"dct/cmd/generator/sources"
+)
+
+// unbounded repeats e.g. * and + are cut off so values stay short
+const DEFAULT_MAX_REPEAT = 10
+
+// RandomRegexField is a string matching pattern, anchors and word boundaries
+// are ignored as every value is the whole match
+type RandomRegexField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Pattern   string `json:"pattern"`
+		MaxRepeat int    `json:"maxRepeat"`
+	} `json:"config"`
+
+	re *syntax.Regexp
+}
+
+func (s *RandomRegexField) prepare(ctx context.Context) error {
+	if s.Config.Pattern == "" {
+		return fmt.Errorf("expected a pattern in the config of field %s", s.Field)
+	}
+	re, err := syntax.Parse(s.Config.Pattern, syntax.Perl)
+	if err != nil {
+		return fmt.Errorf("failed to parse pattern of field %s: %v", s.Field, err)
+	}
+	s.re = re.Simplify()
+	return nil
+}
+
+func (s *RandomRegexField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	maxRepeat := s.Config.MaxRepeat
+	if maxRepeat <= 0 {
+		maxRepeat = DEFAULT_MAX_REPEAT
+	}
+
+	var b strings.Builder
+	generateMatch(r, &b, s.re, maxRepeat)
+	value := b.String()
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func generateMatch(r *rand.Rand, b *strings.Builder, re *syntax.Regexp, maxRepeat int) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, c := range re.Rune {
+			if re.Flags&syntax.FoldCase != 0 && r.IntN(2) == 0 {
+				c = unicode.SimpleFold(c)
+			}
+			b.WriteRune(c)
+		}
+	case syntax.OpCharClass:
+		b.WriteRune(randomRune(r, re.Rune))
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		// printable ascii
+		b.WriteRune(rune(r.IntN(95) + 32))
+	case syntax.OpCapture:
+		generateMatch(r, b, re.Sub[0], maxRepeat)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			generateMatch(r, b, sub, maxRepeat)
+		}
+	case syntax.OpAlternate:
+		generateMatch(r, b, re.Sub[r.IntN(len(re.Sub))], maxRepeat)
+	case syntax.OpQuest:
+		if r.IntN(2) == 0 {
+			generateMatch(r, b, re.Sub[0], maxRepeat)
+		}
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		lb, ub := re.Min, re.Max
+		if re.Op == syntax.OpStar {
+			lb = 0
+		}
+		if re.Op == syntax.OpPlus {
+			lb = 1
+		}
+		if re.Op != syntax.OpRepeat || ub < 0 {
+			ub = max(lb, maxRepeat)
+		}
+		for range r.IntN(ub-lb+1) + lb {
+			generateMatch(r, b, re.Sub[0], maxRepeat)
+		}
+	}
+}
+
+// randomRune picks from ranges of lo, hi pairs, wider ranges more often.
+// Printable ascii is picked when in the ranges so e.g. [^,] is readable
+func randomRune(r *rand.Rand, ranges []rune) rune {
+	var printable []rune
+	for i := 0; i < len(ranges); i += 2 {
+		lo, hi := max(ranges[i], ' '), min(ranges[i+1], '~')
+		if lo <= hi {
+			printable = append(printable, lo, hi)
+		}
+	}
+	if len(printable) > 0 {
+		ranges = printable
+	}
+
+	var total int
+	for i := 0; i < len(ranges); i += 2 {
+		total += int(ranges[i+1]-ranges[i]) + 1
+	}
+	if total == 0 {
+		return 0
+	}
+
+	n := r.IntN(total)
+	for i := 0; i < len(ranges); i += 2 {
+		size := int(ranges[i+1]-ranges[i]) + 1
+		if n < size {
+			return ranges[i] + rune(n)
+		}
+		n -= size
+	}
+	return ranges[0]
+}
+
+func (s *RandomRegexField) GetName() string {
+	return s.Field
+}
+
+func (s *RandomRegexField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
+// RandomWordsField is lorem ipsum text of between min and max words, a
+// sentence starts with a capital and ends with a full stop
+type RandomWordsField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Min      int  `json:"min"`
+		Max      int  `json:"max"`
+		Sentence bool `json:"sentence"`
+	} `json:"config"`
+}
+
+func (s RandomWordsField) prepare(ctx context.Context) error {
+	if s.Config.Min < 0 || (s.Config.Max > 0 && s.Config.Max < s.Config.Min) {
+		return fmt.Errorf("invalid min and max for field %s, expected 0 <= min <= max, not %d and %d", s.Field, s.Config.Min, s.Config.Max)
+	}
+	return nil
+}
+
+func (s RandomWordsField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	lb := max(s.Config.Min, 1)
+	ub := max(s.Config.Max, lb)
+
+	words := make([]string, r.IntN(ub-lb+1)+lb)
+	for i := range words {
+		words[i] = sources.Words[r.IntN(len(sources.Words))]
+	}
+
+	value := strings.Join(words, " ")
+	if s.Config.Sentence {
+		value = strings.ToUpper(value[:1]) + value[1:] + "."
+	}
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func (s RandomWordsField) GetName() string {
+	return s.Field
+}
+
+func (s RandomWordsField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
+
+// RandomFromFileField is a random line of a text file, blank lines are
+// skipped
+type RandomFromFileField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		File string `json:"file"`
+	} `json:"config"`
+
+	lines []string
+}
+
+func (s *RandomFromFileField) prepare(ctx context.Context) error {
+	if s.Config.File == "" {
+		return fmt.Errorf("expected a file in the config of field %s", s.Field)
+	}
+	lines, err := readLines(s.Config.File)
+	if err != nil {
+		return fmt.Errorf("failed to read values of field %s: %v", s.Field, err)
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("expected at least one line in %s for field %s", s.Config.File, s.Field)
+	}
+	s.lines = lines
+	return nil
+}
+
+func (s *RandomFromFileField) Generate(ctx context.Context) any {
+	r := random(ctx)
+	value := s.lines[r.IntN(len(s.lines))]
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func readLines(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func (s *RandomFromFileField) GetName() string {
+	return s.Field
+}
+
+func (s *RandomFromFileField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE}
+}
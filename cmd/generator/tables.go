@@ -0,0 +1,411 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	UNIFORM_CARDINALITY = "uniform"
+	POISSON_CARDINALITY = "poisson"
+)
+
+var CARDINALITIES = []string{UNIFORM_CARDINALITY, POISSON_CARDINALITY}
+
+// Table is one table of a schema of several tables
+type Table struct {
+	Name   string
+	Lines  int
+	Schema Schema
+}
+
+type rawTables struct {
+	Tables []struct {
+		Table  string          `json:"table"`
+		Lines  int             `json:"lines"`
+		Fields json.RawMessage `json:"fields"`
+	} `json:"tables"`
+}
+
+// isTables is true for a schema of several tables, an object of tables
+// rather than an array of fields
+func isTables(schema []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(schema), []byte("{"))
+}
+
+// parseTables reads the tables of a schema in the order they are generated,
+// tables referenced by others first. Tables are returned with the errors of
+// their fields so they can be validated too, and without when the tables
+// themselves are invalid
+func parseTables(schema []byte) ([]Table, error) {
+	var raw rawTables
+	if err := decodeStrict(schema, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %v", err)
+	}
+	if len(raw.Tables) == 0 {
+		return nil, fmt.Errorf("expected at least one table in schema")
+	}
+
+	var tables []Table
+	var errs []error
+	for _, t := range raw.Tables {
+		if t.Table == "" {
+			return nil, fmt.Errorf("expected a name for every table")
+		}
+		if slices.ContainsFunc(tables, func(other Table) bool { return other.Name == t.Table }) {
+			return nil, fmt.Errorf("duplicate table %s", t.Table)
+		}
+
+		// tables with invalid fields are kept so the rest of them is validated
+		schema, err := parseSchema(t.Fields)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid table %s:\n%v", t.Table, err))
+		}
+		tables = append(tables, Table{t.Table, t.Lines, schema})
+	}
+
+	if err := checkRefs(tables); err != nil {
+		return nil, errors.Join(append(errs, err)...)
+	}
+	sorted, err := sortTables(tables)
+	if err != nil {
+		return nil, errors.Join(append(errs, err)...)
+	}
+	return sorted, errors.Join(errs...)
+}
+
+// checkRefs validates every ref against the table and field it references
+func checkRefs(tables []Table) error {
+	for _, t := range tables {
+		driving := 0
+		for _, ref := range refs(t.Schema) {
+			name := fmt.Sprintf("%s.%s", t.Name, ref.Field)
+			i := slices.IndexFunc(tables, func(other Table) bool { return other.Name == ref.Config.Table })
+			if i < 0 {
+				return fmt.Errorf("unknown table `%s` referenced by %s", ref.Config.Table, name)
+			}
+			if !slices.ContainsFunc(tables[i].Schema, func(f Field) bool { return f.GetName() == ref.Config.Field }) {
+				return fmt.Errorf("unknown field `%s.%s` referenced by %s", ref.Config.Table, ref.Config.Field, name)
+			}
+
+			if ref.Config.Cardinality == nil {
+				continue
+			}
+			if err := ref.Config.Cardinality.validate(name); err != nil {
+				return err
+			}
+			if driving++; driving > 1 {
+				return fmt.Errorf("expected a cardinality on at most one ref of table %s", t.Name)
+			}
+			if t.Lines != 0 {
+				return fmt.Errorf("expected either lines or a cardinality for table %s, the cardinality decides the lines", t.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// sortTables orders tables after the tables they reference
+func sortTables(tables []Table) ([]Table, error) {
+	var sorted []Table
+	for len(sorted) < len(tables) {
+		added := false
+		for _, t := range tables {
+			if slices.ContainsFunc(sorted, func(s Table) bool { return s.Name == t.Name }) {
+				continue
+			}
+			ready := !slices.ContainsFunc(refs(t.Schema), func(ref *RefField) bool {
+				return !slices.ContainsFunc(sorted, func(s Table) bool { return s.Name == ref.Config.Table })
+			})
+			if ready {
+				sorted = append(sorted, t)
+				added = true
+			}
+		}
+
+		if !added {
+			var cycle []string
+			for _, t := range tables {
+				if !slices.ContainsFunc(sorted, func(s Table) bool { return s.Name == t.Name }) {
+					cycle = append(cycle, t.Name)
+				}
+			}
+			return nil, fmt.Errorf("tables reference each other in a cycle: %s", strings.Join(cycle, ", "))
+		}
+	}
+	return sorted, nil
+}
+
+// unwrap is the field under any faults or recording
+func unwrap(f Field) Field {
+	for {
+		switch wrapper := f.(type) {
+		case *FaultyField:
+			f = wrapper.Field
+		case *recordedField:
+			f = wrapper.Field
+		case *UniqueField:
+			f = wrapper.Field
+		default:
+			return f
+		}
+	}
+}
+
+func refs(schema Schema) []*RefField {
+	var found []*RefField
+	for _, f := range schema {
+		if ref, ok := unwrap(f).(*RefField); ok {
+			found = append(found, ref)
+		}
+	}
+	return found
+}
+
+// schemaContext is the context fields of a table are generated in
+func schemaContext(ctx context.Context, schema Schema) context.Context {
+	fieldMap := make(FieldMap)
+	for i, f := range schema {
+		fieldMap[f.GetName()] = i
+	}
+
+	ctx = context.WithValue(ctx, SCHEMA_KEY, schema)
+	return context.WithValue(ctx, FIELD_MAP_KEY, fieldMap)
+}
+
+// link records the values written to every referenced field and gives the
+// refs their type, tables are generated in order so values are recorded
+// before they are read. Linking tables again keeps the recorded fields
+func link(ctx context.Context, tables []Table) []context.Context {
+	var contexts []context.Context
+	for i, t := range tables {
+		tableCtx := schemaContext(ctx, t.Schema)
+		contexts = append(contexts, tableCtx)
+
+		for j, f := range t.Schema {
+			recorded, _ := f.(*recordedField)
+			for _, child := range tables[i+1:] {
+				for _, ref := range refs(child.Schema) {
+					if ref.Config.Table != t.Name || ref.Config.Field != f.GetName() {
+						continue
+					}
+					if recorded == nil {
+						recorded = &recordedField{Field: f, values: new([]any)}
+						t.Schema[j] = recorded
+					}
+
+					ref.values = recorded.values
+					ref.fieldType = f.Type(tableCtx)
+					ref.fieldType.Nullable = false
+				}
+			}
+		}
+	}
+	return contexts
+}
+
+// plan draws the rows of tables with a cardinality, their number of rows is
+// only known once the referenced table is written
+func (t Table) plan(r *rand.Rand) int {
+	for _, ref := range refs(t.Schema) {
+		if ref.Config.Cardinality != nil {
+			ref.plan = nil
+			for parent := range len(*ref.values) {
+				for range ref.Config.Cardinality.draw(r) {
+					ref.plan = append(ref.plan, parent)
+				}
+			}
+			return len(ref.plan)
+		}
+	}
+	return t.Lines
+}
+
+// validateTables validates the fields of every table, refs have the types
+// of the fields they reference
+func validateTables(ctx context.Context, tables []Table) error {
+	var errs []error
+	for i, tableCtx := range link(ctx, tables) {
+		if err := validate(tableCtx, tables[i].Schema); err != nil {
+			errs = append(errs, fmt.Errorf("invalid table %s:\n%v", tables[i].Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TablesDDL is the create table statements of every table as a script, in
+// the order tables are generated so referenced tables are created first
+func TablesDDL(ctx context.Context, tables []Table) string {
+	var statements []string
+	for i, tableCtx := range link(ctx, tables) {
+		statements = append(statements, DDL(tableCtx, tables[i].Schema, tables[i].Name, constraints(tables, i))+";")
+	}
+	return strings.Join(statements, "\n\n")
+}
+
+// constraints are the foreign keys of the refs of a table and unique on its
+// referenced fields, a foreign key needs the values it references to be
+// unique so refs to fields that may repeat values have none
+func constraints(tables []Table, i int) map[string]string {
+	found := make(map[string]string)
+	for _, f := range tables[i].Schema {
+		for _, child := range tables[i+1:] {
+			for _, ref := range refs(child.Schema) {
+				if ref.Config.Table == tables[i].Name && ref.Config.Field == f.GetName() && referenceable(f) {
+					found[f.GetName()] = "UNIQUE"
+				}
+			}
+		}
+	}
+
+	for _, ref := range refs(tables[i].Schema) {
+		for _, parent := range tables[:i] {
+			j := slices.IndexFunc(parent.Schema, func(f Field) bool { return f.GetName() == ref.Config.Field })
+			if parent.Name == ref.Config.Table && j >= 0 && referenceable(parent.Schema[j]) {
+				found[ref.Field] = strings.TrimSpace(fmt.Sprintf("%s REFERENCES %s(\"%s\")", found[ref.Field], parent.Name, ref.Config.Field))
+			}
+		}
+	}
+	return found
+}
+
+// referenceable is true for fields that never repeat a value, faults may
+// repeat or empty values
+func referenceable(f Field) bool {
+	for {
+		switch field := f.(type) {
+		case *recordedField:
+			f = field.Field
+		case *UniqueField, *UUIDField, *SequenceField:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// WriteTables writes each table to a file named after it in dir, the tables
+// are validated first by validateTables
+func WriteTables(ctx context.Context, dir string, tables []Table, lines int, options WriteOptions) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	format := ctx.Value(FORMAT_KEY).(string)
+	r := random(ctx)
+	for i, tableCtx := range link(ctx, tables) {
+		t := tables[i]
+		if t.Lines == 0 {
+			t.Lines = lines
+		}
+		n := t.plan(r)
+		for _, ref := range refs(t.Schema) {
+			if n > 0 && len(*ref.values) == 0 {
+				return fmt.Errorf("no values of %s.%s to reference from %s.%s", ref.Config.Table, ref.Config.Field, t.Name, ref.Field)
+			}
+		}
+
+		if err := Write(tableCtx, filepath.Join(dir, t.Name+format), n, options); err != nil {
+			return fmt.Errorf("failed to write table %s: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// recordedField keeps the values written to a field for refs to it, nulls
+// are left out as they can't be referenced
+type recordedField struct {
+	Field
+	values *[]any
+}
+
+func (s *recordedField) prepare(ctx context.Context) error {
+	return prepareField(ctx, s.Field)
+}
+
+func (s *recordedField) Generate(ctx context.Context) any {
+	value := s.Field.Generate(ctx)
+	if value != nil {
+		*s.values = append(*s.values, value)
+	}
+	return value
+}
+
+// Cardinality is the number of rows referencing each row of another table
+type Cardinality struct {
+	Distribution string `json:"distribution"`
+	Min          int    `json:"min"`
+	Max          int    `json:"max"`
+	Lambda       int    `json:"lambda"`
+}
+
+func (c Cardinality) validate(field string) error {
+	switch c.Distribution {
+	case UNIFORM_CARDINALITY:
+		if c.Min < 0 || c.Max < c.Min {
+			return fmt.Errorf("expected 0 <= min <= max for the cardinality of %s", field)
+		}
+	case POISSON_CARDINALITY:
+		if c.Lambda < 1 {
+			return fmt.Errorf("expected a lambda of at least 1 for the cardinality of %s", field)
+		}
+	default:
+		return fmt.Errorf("unsupported cardinality `%s` for %s, expected one of %v", c.Distribution, field, CARDINALITIES)
+	}
+	return nil
+}
+
+func (c Cardinality) draw(r *rand.Rand) int {
+	if c.Distribution == POISSON_CARDINALITY {
+		// generatePoisson counts the arrival past 1 too
+		return generatePoisson(r, c.Lambda) - 1
+	}
+	return r.IntN(c.Max-c.Min+1) + c.Min
+}
+
+// RefField takes values of a field of another table, the referenced table is
+// written first so every value exists. With a cardinality each referenced
+// row is repeated a drawn number of times in order, otherwise each row
+// references a random one
+type RefField struct {
+	Field  string `json:"field"`
+	Source string `json:"source"`
+	Config struct {
+		Table       string       `json:"table"`
+		Field       string       `json:"field"`
+		Cardinality *Cardinality `json:"cardinality"`
+	} `json:"config"`
+
+	values    *[]any
+	fieldType LogicalType
+	plan      []int
+}
+
+func (s *RefField) Generate(ctx context.Context) any {
+	var value any
+	if s.Config.Cardinality != nil {
+		value = (*s.values)[s.plan[rowIndex(ctx)]]
+	} else {
+		value = (*s.values)[random(ctx).IntN(len(*s.values))]
+	}
+
+	putValue(ctx, s.Field, value)
+	return value
+}
+
+func (s *RefField) GetName() string {
+	return s.Field
+}
+
+// Type is the type of the referenced field
+func (s *RefField) Type(ctx context.Context) LogicalType {
+	return s.fieldType
+}
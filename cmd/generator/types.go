@@ -0,0 +1,189 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"dct/cmd/utils"
+)
+
+const (
+	INT_TYPE       = "int"
+	FLOAT_TYPE     = "float"
+	BOOL_TYPE      = "bool"
+	DATE_TYPE      = "date"
+	TIME_TYPE      = "time"
+	TIMESTAMP_TYPE = "timestamp"
+	STRING_TYPE    = "string"
+
+	// decimals have a precision and scale so derived fields can't declare them
+	DECIMAL_TYPE = "decimal"
+)
+
+var LOGICAL_TYPES = []string{INT_TYPE, FLOAT_TYPE, BOOL_TYPE, DATE_TYPE, TIME_TYPE, TIMESTAMP_TYPE, STRING_TYPE}
+
+// LogicalType is the kind of value a field generates, writers format values
+// by it rather than by their go type
+type LogicalType struct {
+	Name      string
+	Nullable  bool
+	Precision int
+	Scale     int
+}
+
+// SQL is the duckdb type of the logical type
+func (t LogicalType) SQL() string {
+	switch t.Name {
+	case INT_TYPE:
+		return "BIGINT"
+	case FLOAT_TYPE:
+		return "DOUBLE"
+	case DECIMAL_TYPE:
+		return fmt.Sprintf("DECIMAL(%d,%d)", t.Precision, t.Scale)
+	case BOOL_TYPE:
+		return "BOOLEAN"
+	case DATE_TYPE:
+		return "DATE"
+	case TIME_TYPE:
+		return "TIME"
+	case TIMESTAMP_TYPE:
+		// timestamps are generated in a timezone and keep their offset
+		return "TIMESTAMPTZ"
+	default:
+		return "VARCHAR"
+	}
+}
+
+// zero is a placeholder value of the logical type, for type checking
+// expressions before any value is generated
+func (t LogicalType) zero() any {
+	switch t.Name {
+	case INT_TYPE:
+		return 0
+	case FLOAT_TYPE, DECIMAL_TYPE:
+		return 0.0
+	case BOOL_TYPE:
+		return false
+	case DATE_TYPE, TIME_TYPE, TIMESTAMP_TYPE:
+		return time.Time{}
+	default:
+		return ""
+	}
+}
+
+// typeOf maps the go type of an expression result to a logical type,
+// anything unknown is written as a string
+func typeOf(t reflect.Type) LogicalType {
+	if t == nil {
+		return LogicalType{Name: STRING_TYPE, Nullable: true}
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return LogicalType{Name: INT_TYPE}
+	case reflect.Float32, reflect.Float64:
+		return LogicalType{Name: FLOAT_TYPE}
+	case reflect.Bool:
+		return LogicalType{Name: BOOL_TYPE}
+	case reflect.String:
+		return LogicalType{Name: STRING_TYPE}
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return LogicalType{Name: TIMESTAMP_TYPE}
+	}
+	return LogicalType{Name: STRING_TYPE, Nullable: true}
+}
+
+func formatTime(t LogicalType, v time.Time) string {
+	switch t.Name {
+	case DATE_TYPE:
+		return v.Format(time.DateOnly)
+	case TIME_TYPE:
+		return v.Format(time.TimeOnly)
+	default:
+		return v.Format(time.RFC3339)
+	}
+}
+
+// text formats a value for csv, nulls are empty
+func text(t LogicalType, v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return formatTime(t, v)
+	case float64:
+		if t.Name == DECIMAL_TYPE {
+			return strconv.FormatFloat(v, 'f', t.Scale, 64)
+		}
+		return fmt.Sprint(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jsonValue formats a value for json, numbers json can't hold are null and
+// fields typed as strings are always quoted
+func jsonValue(t LogicalType, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return []byte("null"), nil
+	case time.Time:
+		return json.Marshal(formatTime(t, v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return []byte("null"), nil
+		}
+		if t.Name == DECIMAL_TYPE {
+			return []byte(text(t, v)), nil
+		}
+	}
+
+	if t.Name == STRING_TYPE {
+		return json.Marshal(text(t, v))
+	}
+	return json.Marshal(v)
+}
+
+func schemaTypes(ctx context.Context, schema Schema) []LogicalType {
+	var types []LogicalType
+	for _, f := range schema {
+		types = append(types, f.Type(ctx))
+	}
+	return types
+}
+
+// DDL is the create table statement matching the generated data, constraints
+// are added to the columns of the fields they are named after
+func DDL(ctx context.Context, schema Schema, table string, constraints map[string]string) string {
+	var result utils.Result
+	for i, t := range schemaTypes(ctx, schema) {
+		sqlType := t.SQL()
+		if !t.Nullable {
+			sqlType += " NOT NULL"
+		}
+		if constraint, ok := constraints[schema[i].GetName()]; ok {
+			sqlType += " " + constraint
+		}
+		result.Headers = append(result.Headers, utils.Header{Name: schema[i].GetName(), Type: sqlType})
+	}
+
+	return result.ToSQL(table)
+}
+
+// duckdbColumns lists the columns of the schema as a duckdb struct literal of
+// names to types, for reading generated json
+func duckdbColumns(ctx context.Context, schema Schema) string {
+	var cols []string
+	for i, t := range schemaTypes(ctx, schema) {
+		name := strings.ReplaceAll(schema[i].GetName(), "'", "''")
+		cols = append(cols, fmt.Sprintf("'%s': '%s'", name, t.SQL()))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(cols, ", "))
+}
@@ -0,0 +1,64 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// values are drawn again until one is new, a field that can't find one in
+// this many draws has likely run out of values
+const MAX_UNIQUE_RETRIES = 1000
+
+// withUnique wraps a field when its config asks for unique values
+func withUnique(field Field, unique bool, faults Faults) (Field, error) {
+	if !unique {
+		return field, nil
+	}
+	if faults.DuplicateRate > 0 {
+		return nil, fmt.Errorf("expected no duplicateRate on unique field %s", field.GetName())
+	}
+	if ref, ok := field.(*RefField); ok && ref.Config.Cardinality != nil {
+		return nil, fmt.Errorf("expected no cardinality on unique ref %s, each referenced row is repeated", field.GetName())
+	}
+
+	return &UniqueField{Field: field, seen: make(map[string]struct{})}, nil
+}
+
+// UniqueField draws values of a field until one hasn't been generated before,
+// values are compared as written so e.g. dates of the same day are equal
+type UniqueField struct {
+	Field
+	seen      map[string]struct{}
+	fieldType LogicalType
+}
+
+func (s *UniqueField) prepare(ctx context.Context) error {
+	if err := prepareField(ctx, s.Field); err != nil {
+		return err
+	}
+	s.fieldType = s.Field.Type(ctx)
+	return nil
+}
+
+func (s *UniqueField) Generate(ctx context.Context) any {
+	for range MAX_UNIQUE_RETRIES {
+		value := s.Field.Generate(ctx)
+		// nulls are not values so never repeat
+		if value == nil {
+			return nil
+		}
+
+		key := text(s.fieldType, value)
+		if _, ok := s.seen[key]; !ok {
+			s.seen[key] = struct{}{}
+			return value
+		}
+	}
+
+	log.Fatalf(
+		"Error: failed to generate a unique value for field %s in %d tries, it may have run out after %d values\n",
+		s.GetName(), MAX_UNIQUE_RETRIES, len(s.seen),
+	)
+	return nil
+}
@@ -0,0 +1,280 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var SOURCES = []string{
+	"randomBool",
+	"randomEnum",
+	"randomAscii",
+	"randomUniformInt",
+	"randomNormal",
+	"randomUniformFloat",
+	"randomLognormal",
+	"randomExponential",
+	"randomBeta",
+	"randomDecimal",
+	"randomPoisson",
+	"randomDatetime",
+	"randomDate",
+	"randomTime",
+	"randomRegex",
+	"randomWords",
+	"randomFromFile",
+	"uuid",
+	"firstNames",
+	"lastNames",
+	"companies",
+	"emails",
+	"sequence",
+	"monotonicDatetime",
+	"derived",
+	"ref",
+}
+
+// REQUIRED_CONFIG are the config keys of sources that have no sensible
+// default, other keys of a config can be left out
+var REQUIRED_CONFIG = map[string][]string{
+	"randomAscii":        {"length"},
+	"randomUniformInt":   {"min", "max"},
+	"randomNormal":       {"mean", "std"},
+	"randomUniformFloat": {"min", "max"},
+	"randomLognormal":    {"mu", "sigma"},
+	"randomExponential":  {"rate"},
+	"randomBeta":         {"alpha", "beta"},
+	"randomDecimal":      {"precision"},
+	"randomPoisson":      {"lambda"},
+	"randomRegex":        {"pattern"},
+	"randomFromFile":     {"file"},
+	"derived":            {"expression"},
+	"ref":                {"table", "field"},
+}
+
+// requireConfig reports the required config keys a field leaves out, a
+// config that isn't an object is reported when the source reads it
+func requireConfig(field rawField) error {
+	var config map[string]json.RawMessage
+	if len(field.Config) > 0 {
+		if err := json.Unmarshal(field.Config, &config); err != nil {
+			return nil
+		}
+	}
+
+	var missing []string
+	for _, key := range REQUIRED_CONFIG[field.Source] {
+		if _, ok := config[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf(
+			"missing %s in the config of field %s, %s expects %s",
+			strings.Join(quoted(missing), ", "), field.Field, field.Source, strings.Join(quoted(REQUIRED_CONFIG[field.Source]), ", "),
+		)
+	}
+	return nil
+}
+
+func quoted(keys []string) []string {
+	var q []string
+	for _, key := range keys {
+		q = append(q, fmt.Sprintf("`%s`", key))
+	}
+	return q
+}
+
+// invalidField stands in for a field that couldn't be read, it is only
+// validated and never generated
+type invalidField struct {
+	name string
+}
+
+func (s *invalidField) Generate(ctx context.Context) any {
+	return nil
+}
+
+func (s *invalidField) GetName() string {
+	return s.name
+}
+
+func (s *invalidField) Type(ctx context.Context) LogicalType {
+	return LogicalType{Name: STRING_TYPE, Nullable: true}
+}
+
+// rawField is every key a field can have, the config is read by its source
+type rawField struct {
+	Field  string          `json:"field"`
+	Source string          `json:"source"`
+	Config json.RawMessage `json:"config"`
+	Unique bool            `json:"unique"`
+	Faults
+}
+
+type rawSource struct {
+	Field  string          `json:"field"`
+	Source string          `json:"source"`
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+func decodeStrict(raw []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil && err != io.EOF {
+		return jsonError(err)
+	}
+	return nil
+}
+
+// jsonError rewords the errors of decoding a schema in the terms of the
+// schema rather than the go types it is read into
+func jsonError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		key := typeErr.Field
+		if key == "" {
+			return fmt.Errorf("expected %s, not a %s", typeName(typeErr.Type.Kind().String()), typeErr.Value)
+		}
+		return fmt.Errorf("expected %s for `%s`, not a %s", typeName(typeErr.Type.Kind().String()), key, typeErr.Value)
+	}
+
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if unquoted, uerr := strconv.Unquote(name); uerr == nil {
+			name = unquoted
+		}
+		return fmt.Errorf("unknown key `%s`", name)
+	}
+	return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+}
+
+// typeName is the json name of a go kind
+func typeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"):
+		return "an integer"
+	case strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "bool":
+		return "true or false"
+	case kind == "string":
+		return "a string"
+	case kind == "slice", kind == "array":
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// generationOrder is the order the fields of a schema are generated in, the
+// order of the schema except derived fields come after the fields they read.
+// Derived fields reading unknown fields or each other in a cycle are left out
+// of the order and reported
+func generationOrder(schema Schema) ([]int, error) {
+	fieldMap := make(map[string]int)
+	for i, f := range schema {
+		fieldMap[f.GetName()] = i
+	}
+
+	var errs []error
+	reads := make([][]int, len(schema))
+	done := make([]bool, len(schema))
+	unknown := make([]bool, len(schema))
+	for i, f := range schema {
+		derived, ok := unwrap(f).(*DerivedField)
+		if !ok {
+			continue
+		}
+		for _, name := range derived.Config.Fields {
+			j, ok := fieldMap[name]
+			if !ok {
+				errs = append(errs, fmt.Errorf("unknown field `%s` read by derived field %s", name, f.GetName()))
+				unknown[i] = true
+				continue
+			}
+			reads[i] = append(reads[i], j)
+		}
+	}
+
+	var order []int
+	for {
+		added := false
+		for i := range schema {
+			if done[i] || unknown[i] || slices.ContainsFunc(reads[i], func(j int) bool { return !done[j] }) {
+				continue
+			}
+			order = append(order, i)
+			done[i] = true
+			added = true
+		}
+		if !added {
+			break
+		}
+	}
+
+	// fields left are in a cycle or read one, or read a field reading an
+	// unknown field which is already reported
+	var cycle []string
+	for i, f := range schema {
+		if !done[i] && !unknown[i] && inCycle(i, reads) {
+			cycle = append(cycle, f.GetName())
+		}
+	}
+	if len(cycle) > 0 {
+		errs = append(errs, fmt.Errorf("derived fields read each other in a cycle: %s", strings.Join(cycle, ", ")))
+	}
+	return order, errors.Join(errs...)
+}
+
+// inCycle is whether field i reads itself through the fields it reads
+func inCycle(i int, reads [][]int) bool {
+	seen := make([]bool, len(reads))
+	next := slices.Clone(reads[i])
+	for len(next) > 0 {
+		j := next[len(next)-1]
+		next = next[:len(next)-1]
+		if j == i {
+			return true
+		}
+		if !seen[j] {
+			seen[j] = true
+			next = append(next, reads[j]...)
+		}
+	}
+	return false
+}
+
+// validate prepares every field of the schema and reports every problem
+// found. A derived field isn't prepared when a field it reads couldn't be
+// read or a derived field it reads failed, as their types can't be known
+func validate(ctx context.Context, schema Schema) error {
+	order, err := generationOrder(schema)
+	errs := []error{err}
+
+	failed := make(map[string]bool)
+	for _, i := range order {
+		f := schema[i]
+		if _, ok := f.(*invalidField); ok {
+			failed[f.GetName()] = true
+			continue
+		}
+
+		derived, isDerived := unwrap(f).(*DerivedField)
+		if isDerived && slices.ContainsFunc(derived.Config.Fields, func(name string) bool { return failed[name] }) {
+			failed[f.GetName()] = true
+			continue
+		}
+		if err := prepareField(ctx, f); err != nil {
+			errs = append(errs, err)
+			failed[f.GetName()] = isDerived
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,102 +1,332 @@
 package generator
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"dct/cmd/utils"
 )
 
-func Write(ctx context.Context, out io.Writer, lines int) {
+// rows are generated in chunks, each with its own random source drawn from
+// the seed, so the output of a seed is the same for any number of workers
+const CHUNK_ROWS = 10_000
+
+type WriteOptions struct {
+	Workers     int
+	RowsPerFile int
+	Parquet     ParquetOptions
+}
+
+type ParquetOptions struct {
+	Compression  string
+	RowGroupSize int
+}
+
+// row is the row a worker is generating, fields read their row number and the
+// values of the fields before them from it
+type row struct {
+	index  int
+	values utils.Cache
+}
+
+func currentRow(ctx context.Context) *row {
+	r, ok := ctx.Value(ROW_KEY).(*row)
+	if !ok {
+		log.Fatalln("failed to read row from context")
+	}
+	return r
+}
+
+func putValue(ctx context.Context, field string, value any) {
+	currentRow(ctx).values.PutValue(field, value)
+}
+
+func valueOf(ctx context.Context, field string) any {
+	return currentRow(ctx).values.GetValue(field)
+}
+
+func rowIndex(ctx context.Context) int {
+	return currentRow(ctx).index
+}
+
+// chunk is a run of rows formatted by a worker, ends are the offsets in buf
+// after each row so the writer can split files between them
+type chunk struct {
+	first int
+	rows  int
+	buf   bytes.Buffer
+	ends  []int
+	done  chan struct{}
+}
+
+// Write generates lines rows of the schema in the context to outfile, or to
+// stdout when empty. Rows are generated on workers in chunks and written in
+// order, into files of rowsPerFile rows when set. The schema is validated
+// first by validate, which prepares its fields
+func Write(ctx context.Context, outfile string, lines int, options WriteOptions) error {
 	schema, ok := ctx.Value(SCHEMA_KEY).(Schema)
 	if !ok {
-		log.Fatalln("failed to read schema from context")
+		return fmt.Errorf("failed to read schema from context")
 	}
 
 	format, ok := ctx.Value(FORMAT_KEY).(string)
 	if !ok {
-		log.Fatalln("failed to read format from context")
+		return fmt.Errorf("failed to read format from context")
 	}
 
-	switch format {
-	case utils.NDJSON:
-		writeJSON(ctx, out, schema, lines)
-	case utils.CSV:
-		writeCsv(ctx, out, schema, lines)
+	order, err := generationOrder(schema)
+	if err != nil {
+		return err
 	}
-}
 
-func writeCsv(ctx context.Context, out io.Writer, schema Schema, lines int) {
-	fields := len(schema)
+	types := schemaTypes(ctx, schema)
+	var names [][]byte
+	for _, f := range schema {
+		name, _ := json.Marshal(f.GetName())
+		names = append(names, name)
+	}
 
-	for i := range lines {
-		// headers
-		if i == 0 {
-			for i, f := range schema {
-				name := f.GetName()
-				if strings.Contains(name, `"`) {
-					name = strings.ReplaceAll(name, `"`, `""`)
-				}
-				if strings.Contains(name, ",") {
-					name = fmt.Sprintf(`"%s"`, name)
-				}
+	sink := &sink{
+		format:  format,
+		outfile: outfile,
+		header:  csvHeader(schema),
+		columns: duckdbColumns(ctx, schema),
+		options: options,
+	}
+	if err := sink.open(); err != nil {
+		return err
+	}
 
-				_, _ = fmt.Fprintf(out, "%s", name)
-				if i < fields-1 {
-					_, _ = fmt.Fprintf(out, ",")
-				} else {
-					_, _ = fmt.Fprintf(out, "\n")
-				}
-			}
+	// fields that depend on the rows before them are generated in order
+	workers := max(options.Workers, 1)
+	if sequential(schema) {
+		workers = 1
+	}
+
+	// the chunk sources are drawn once so every chunk has a different one
+	seed := random(ctx).Uint64()
+	jobs := make(chan *chunk)
+	queue := make(chan *chunk, 2*workers)
+	go func() {
+		for first := 0; first < lines; first += CHUNK_ROWS {
+			c := &chunk{first: first, rows: min(CHUNK_ROWS, lines-first), done: make(chan struct{})}
+			queue <- c
+			jobs <- c
 		}
+		close(queue)
+		close(jobs)
+	}()
+
+	for range workers {
+		go func() {
+			for c := range jobs {
+				generateChunk(ctx, c, seed, schema, order, names, types, format, options.RowsPerFile)
+				close(c.done)
+			}
+		}()
+	}
 
-		for i, f := range schema {
-			value := f.Generate(ctx)
-			if v, ok := value.(string); ok {
-				if strings.Contains(v, `"`) {
-					value = strings.ReplaceAll(v, `"`, `""`)
+	for c := range queue {
+		<-c.done
+		start := 0
+		for i, end := range c.ends {
+			index := c.first + i
+			if options.RowsPerFile > 0 && index > 0 && index%options.RowsPerFile == 0 {
+				if err := sink.close(); err != nil {
+					return err
 				}
-				if strings.Contains(v, ",") {
-					value = fmt.Sprintf(`"%s"`, value)
+				if err := sink.open(); err != nil {
+					return err
 				}
 			}
 
-			_, _ = fmt.Fprintf(out, "%v", value)
-			if i < fields-1 {
-				_, _ = fmt.Fprintf(out, ",")
-			} else {
-				_, _ = fmt.Fprintf(out, "\n")
+			if _, err := sink.out.Write(c.buf.Bytes()[start:end]); err != nil {
+				return err
 			}
+			start = end
 		}
 	}
+
+	return sink.close()
 }
 
-func writeJSON(ctx context.Context, out io.Writer, schema Schema, lines int) {
-	for range lines {
-		_, _ = fmt.Fprint(out, "{")
-		for i, f := range schema {
-			value := f.Generate(ctx)
+func generateChunk(ctx context.Context, c *chunk, seed uint64, schema Schema, order []int, names [][]byte, types []LogicalType, format string, rowsPerFile int) {
+	state := &row{values: utils.NewCache()}
+	ctx = context.WithValue(ctx, RAND_KEY, rand.New(rand.NewPCG(seed, uint64(c.first/CHUNK_ROWS))))
+	ctx = context.WithValue(ctx, ROW_KEY, state)
 
-			switch value.(type) {
-			case float32, float64, int, int32, int64, bool:
-				_, _ = fmt.Fprintf(out, `"%s":%v`, f.GetName(), value)
-			case string:
-				v, err := json.Marshal(value)
-				if err != nil {
-					log.Fatalf("failed to write `%s: %v` as json: %v", f.GetName(), value, err)
-				}
-				_, _ = fmt.Fprintf(out, `"%s":%s`, f.GetName(), v)
-			}
+	values := make([]any, len(schema))
+	for i := range c.rows {
+		state.index = c.first + i
+		for _, j := range order {
+			values[j] = schema[j].Generate(ctx)
+		}
 
-			if i != len(schema)-1 {
-				_, _ = fmt.Fprint(out, ",")
+		switch format {
+		case utils.CSV:
+			writeCsvRow(&c.buf, types, values)
+		case utils.JSON:
+			// rows are split between files at multiples of rowsPerFile
+			if state.index == 0 || (rowsPerFile > 0 && state.index%rowsPerFile == 0) {
+				c.buf.WriteString("\n  ")
+			} else {
+				c.buf.WriteString(",\n  ")
 			}
+			writeObject(&c.buf, names, types, values)
+		default:
+			writeObject(&c.buf, names, types, values)
+			c.buf.WriteByte('\n')
+		}
+		c.ends = append(c.ends, c.buf.Len())
+	}
+}
+
+// sink is the file rows are written to, parquet is written as ndjson into
+// a temporary file then copied into typed columns by duckdb when closed
+type sink struct {
+	format  string
+	outfile string
+	header  string
+	columns string
+	options WriteOptions
+
+	part int
+	path string
+	file *os.File
+	out  *bufio.Writer
+}
+
+// partPath numbers the files when output is split e.g. data-00001.csv
+func partPath(outfile string, part int) string {
+	ext := filepath.Ext(outfile)
+	return fmt.Sprintf("%s-%05d%s", strings.TrimSuffix(outfile, ext), part, ext)
+}
+
+func (s *sink) open() error {
+	s.path = s.outfile
+	if s.options.RowsPerFile > 0 {
+		s.path = partPath(s.outfile, s.part)
+	}
+	s.part++
+
+	var err error
+	switch {
+	case s.format == utils.PARQUET:
+		s.file, err = os.CreateTemp("", "dct-gen-*.ndjson")
+	case s.path != "":
+		s.file, err = os.Create(s.path)
+	default:
+		s.file = nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create out file: %v", err)
+	}
+
+	if s.file != nil {
+		s.out = bufio.NewWriterSize(s.file, 1<<20)
+	} else {
+		s.out = bufio.NewWriterSize(os.Stdout, 1<<20)
+	}
+
+	switch s.format {
+	case utils.CSV:
+		_, err = s.out.WriteString(s.header)
+	case utils.JSON:
+		_, err = s.out.WriteString("[")
+	}
+	return err
+}
+
+func (s *sink) close() error {
+	if s.format == utils.JSON {
+		if _, err := s.out.WriteString("\n]\n"); err != nil {
+			return err
 		}
+	}
+	if err := s.out.Flush(); err != nil {
+		return err
+	}
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	if s.format == utils.PARQUET {
+		defer func() { _ = os.Remove(s.file.Name()) }()
+		if err := utils.Execute(generateParquetSQL(s.file.Name(), s.columns, s.path, s.options.Parquet)); err != nil {
+			return fmt.Errorf("failed to write parquet: %v", err)
+		}
+	}
+	return nil
+}
+
+// generateParquetSQL reads the rows with the types of their fields, columns is
+// a duckdb struct of names to types
+func generateParquetSQL(ndjson string, columns string, outfile string, options ParquetOptions) string {
+	return fmt.Sprintf(
+		"copy (select * from read_ndjson('%s', columns = %s)) to '%s' (format parquet, compression %s, row_group_size %d)",
+		strings.ReplaceAll(ndjson, "'", "''"),
+		columns,
+		strings.ReplaceAll(outfile, "'", "''"),
+		options.Compression,
+		options.RowGroupSize,
+	)
+}
+
+// csvValue quotes values with delimiters, quotes or line breaks, quotes
+// inside are doubled
+func csvValue(value string) string {
+	if !strings.ContainsAny(value, ",\"\n\r") {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `""`) + `"`
+}
 
-		_, _ = fmt.Fprintln(out, "}")
+func csvHeader(schema Schema) string {
+	var names []string
+	for _, f := range schema {
+		names = append(names, csvValue(f.GetName()))
+	}
+	return strings.Join(names, ",") + "\n"
+}
+
+func writeCsvRow(b *bytes.Buffer, types []LogicalType, values []any) {
+	for i, value := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(csvValue(text(types[i], value)))
+	}
+	b.WriteByte('\n')
+}
+
+// writeObject writes a row as a json object of the json encoded names of the
+// fields, values are written by the logical type of their field so every
+// field is present
+func writeObject(b *bytes.Buffer, names [][]byte, types []LogicalType, values []any) {
+	b.WriteByte('{')
+	for i, name := range names {
+		v, err := jsonValue(types[i], values[i])
+		if err != nil {
+			log.Fatalf("failed to write `%s: %v` as json: %v", name, values[i], err)
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(v)
+
+		if i != len(names)-1 {
+			b.WriteByte(',')
+		}
 	}
+	b.WriteByte('}')
 }
@@ -0,0 +1,424 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	"dct/cmd/utils"
+)
+
+const (
+	COLUMN_METRIC         = "column"
+	TYPE_METRIC           = "type"
+	NULL_RATE_METRIC      = "null_rate"
+	MEAN_METRIC           = "mean"
+	QUANTILES_METRIC      = "quantiles"
+	PSI_METRIC            = "psi"
+	KS_METRIC             = "ks"
+	NEW_CATEGORIES_METRIC = "new_categories"
+
+	// a column drifts when any of its metrics exceed these, shifts of the
+	// mean and quantiles are in baseline standard deviations
+	NULL_RATE_THRESHOLD = 0.05
+	SHIFT_THRESHOLD     = 0.5
+	PSI_THRESHOLD       = 0.2
+	KS_THRESHOLD        = 0.1
+
+	// stands in for empty bins so psi stays finite
+	PSI_EPSILON = 1e-4
+
+	// baselines keep the values of columns with up to this many in the
+	// sample, more are not categories
+	MAX_CATEGORIES = 1000
+)
+
+// Drift compares a profile with a baseline profile saved by an earlier run
+type Drift struct {
+	Baseline     string   `json:"baseline"`
+	File         string   `json:"file"`
+	BaselineRows int      `json:"baseline_rows"`
+	Rows         int      `json:"rows"`
+	Metrics      []Metric `json:"metrics"`
+	Drifted      []string `json:"drifted"`
+}
+
+type Metric struct {
+	Column    string  `json:"column"`
+	Metric    string  `json:"metric"`
+	Baseline  string  `json:"baseline"`
+	Current   string  `json:"current"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Drifted   bool    `json:"drifted"`
+}
+
+func readBaseline(file string) (ProfileReport, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return ProfileReport{}, err
+	}
+
+	var baseline ProfileReport
+	if err := json.Unmarshal(b, &baseline); err != nil {
+		return ProfileReport{}, fmt.Errorf("expected a profile saved with --save: %v", err)
+	}
+	return baseline, nil
+}
+
+func saveReport(file string, report ProfileReport) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	return report.WriteJSON(f)
+}
+
+// generateBinCountSQL counts the values of a column in the bins of a baseline
+// histogram, the first and last bins are open so every value is counted
+func generateBinCountSQL(relation string, header utils.Header, bins []Bin) string {
+	col := utils.QuoteIdent(header.Name)
+	value := col + "::double"
+	if isTemporal(header.Type) {
+		value = fmt.Sprintf("epoch(%s)", col)
+	}
+
+	var counts []string
+	for i := range bins {
+		var conds []string
+		if i > 0 {
+			conds = append(conds, fmt.Sprintf("v >= %v", bins[i].Start))
+		}
+		if i < len(bins)-1 {
+			conds = append(conds, fmt.Sprintf("v < %v", bins[i].End))
+		}
+		if len(conds) == 0 {
+			conds = append(conds, "true")
+		}
+		counts = append(counts, fmt.Sprintf("count_if(%s)::bigint", strings.Join(conds, " and ")))
+	}
+
+	return fmt.Sprintf(
+		"select %s from (select %s as v from %s where %s is not null)",
+		strings.Join(counts, ", "),
+		value,
+		relation,
+		col,
+	)
+}
+
+func binCounts(relation string, header utils.Header, bins []Bin) ([]int, error) {
+	result, err := utils.Query(generateBinCountSQL(relation, header, bins))
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []int
+	for _, v := range result.Rows[0] {
+		n, _ := v.(int)
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+func fraction(n, total int) float64 {
+	return ratio(n, total) / 100
+}
+
+func proportions(counts []int) []float64 {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+
+	ps := make([]float64, len(counts))
+	for i, n := range counts {
+		ps[i] = fraction(n, total)
+	}
+	return ps
+}
+
+// PSI is the population stability index between two distributions over the
+// same bins
+func PSI(expected []float64, actual []float64) float64 {
+	psi := 0.0
+	for i := range expected {
+		e, a := max(expected[i], PSI_EPSILON), max(actual[i], PSI_EPSILON)
+		psi += (a - e) * math.Log(a/e)
+	}
+	return psi
+}
+
+// KS is the largest gap between the cumulative distributions over the same
+// bins, an approximation of the Kolmogorov-Smirnov statistic
+func KS(expected []float64, actual []float64) float64 {
+	ks, e, a := 0.0, 0.0, 0.0
+	for i := range expected {
+		e += expected[i]
+		a += actual[i]
+		ks = max(ks, math.Abs(a-e))
+	}
+	return ks
+}
+
+func (d *Drift) add(m Metric) {
+	m.Drifted = m.Value > m.Threshold
+	d.Metrics = append(d.Metrics, m)
+	if m.Drifted && !slices.Contains(d.Drifted, m.Column) {
+		d.Drifted = append(d.Drifted, m.Column)
+	}
+}
+
+// compare measures how far each column of a profile has moved from the
+// baseline, values counted in sample back the categorical metrics and
+// relation is binned like the baseline
+func compare(baseline ProfileReport, current ProfileReport, relation string, sample utils.Result) (Drift, error) {
+	d := Drift{
+		Baseline:     baseline.File,
+		File:         current.File,
+		BaselineRows: baseline.Rows,
+		Rows:         current.Rows,
+		Metrics:      []Metric{},
+		Drifted:      []string{},
+	}
+
+	columns := make(map[string]int)
+	for i, c := range current.Columns {
+		columns[c.Name] = i
+	}
+
+	for _, base := range baseline.Columns {
+		i, ok := columns[base.Name]
+		if !ok {
+			d.add(Metric{Column: base.Name, Metric: COLUMN_METRIC, Baseline: "present", Current: "missing", Value: 1})
+			continue
+		}
+
+		cur := current.Columns[i]
+		if base.Type != cur.Type {
+			d.add(Metric{Column: base.Name, Metric: TYPE_METRIC, Baseline: base.Type, Current: cur.Type, Value: 1})
+			continue
+		}
+
+		baseNulls, curNulls := fraction(base.Nulls, base.Count), fraction(cur.Nulls, cur.Count)
+		d.add(Metric{
+			Column:    base.Name,
+			Metric:    NULL_RATE_METRIC,
+			Baseline:  fmt.Sprintf("%.4f", baseNulls),
+			Current:   fmt.Sprintf("%.4f", curNulls),
+			Value:     math.Abs(curNulls - baseNulls),
+			Threshold: NULL_RATE_THRESHOLD,
+		})
+
+		if base.Numeric != nil && cur.Numeric != nil {
+			compareNumeric(&d, base.Name, *base.Numeric, *cur.Numeric)
+		}
+
+		var err error
+		if len(base.Histogram) > 0 {
+			err = compareBins(&d, relation, base)
+		} else {
+			compareCategories(&d, base, CountValues(sample, i))
+		}
+		if err != nil {
+			return Drift{}, fmt.Errorf("failed to compare %s: %v", base.Name, err)
+		}
+	}
+
+	for _, cur := range current.Columns {
+		if !slices.ContainsFunc(baseline.Columns, func(c ColumnReport) bool { return c.Name == cur.Name }) {
+			d.add(Metric{Column: cur.Name, Metric: COLUMN_METRIC, Baseline: "missing", Current: "present", Value: 1})
+		}
+	}
+
+	return d, nil
+}
+
+func compareNumeric(d *Drift, name string, base NumericStats, cur NumericStats) {
+	// a constant baseline has no spread to scale by
+	scale := base.StdDev
+	if scale == 0 {
+		scale = 1
+	}
+
+	d.add(Metric{
+		Column:    name,
+		Metric:    MEAN_METRIC,
+		Baseline:  fmt.Sprintf("%.4f", base.Mean),
+		Current:   fmt.Sprintf("%.4f", cur.Mean),
+		Value:     math.Abs(cur.Mean-base.Mean) / scale,
+		Threshold: SHIFT_THRESHOLD,
+	})
+
+	if len(base.Percentiles) != len(cur.Percentiles) {
+		return
+	}
+	shift := 0.0
+	var baseQs, curQs []string
+	for j := range base.Percentiles {
+		shift = max(shift, math.Abs(cur.Percentiles[j]-base.Percentiles[j])/scale)
+		baseQs = append(baseQs, fmt.Sprintf("%.4g", base.Percentiles[j]))
+		curQs = append(curQs, fmt.Sprintf("%.4g", cur.Percentiles[j]))
+	}
+	d.add(Metric{
+		Column:    name,
+		Metric:    QUANTILES_METRIC,
+		Baseline:  strings.Join(baseQs, ", "),
+		Current:   strings.Join(curQs, ", "),
+		Value:     shift,
+		Threshold: SHIFT_THRESHOLD,
+	})
+}
+
+// compareBins counts the current values in the baseline histogram bins
+func compareBins(d *Drift, relation string, base ColumnReport) error {
+	counts, err := binCounts(relation, utils.Header{Name: base.Name, Type: base.Type}, base.Histogram)
+	if err != nil {
+		return err
+	}
+
+	var baseCounts []int
+	for _, bin := range base.Histogram {
+		baseCounts = append(baseCounts, bin.Count)
+	}
+	expected, actual := proportions(baseCounts), proportions(counts)
+
+	bins := fmt.Sprintf("%d bins from %s to %s", len(base.Histogram), base.Histogram[0].Lower, base.Histogram[len(base.Histogram)-1].Upper)
+	d.add(Metric{
+		Column:    base.Name,
+		Metric:    PSI_METRIC,
+		Baseline:  bins,
+		Current:   bins,
+		Value:     PSI(expected, actual),
+		Threshold: PSI_THRESHOLD,
+	})
+	d.add(Metric{
+		Column:    base.Name,
+		Metric:    KS_METRIC,
+		Baseline:  bins,
+		Current:   bins,
+		Value:     KS(expected, actual),
+		Threshold: KS_THRESHOLD,
+	})
+	return nil
+}
+
+// categories is every value of a sample in value order, none when there are
+// more than MAX_CATEGORIES
+func categories(valueMap map[string]int) []string {
+	if len(valueMap) > MAX_CATEGORIES {
+		return nil
+	}
+
+	var values []string
+	for v := range valueMap {
+		values = append(values, v)
+	}
+	slices.Sort(values)
+	return values
+}
+
+// compareCategories bins values by the baseline top values and the rest, new
+// categories are known when the baseline saved its categories or listed
+// every value
+func compareCategories(d *Drift, base ColumnReport, valueMap map[string]int) {
+	known := make(map[string]bool)
+	var baseCounts, counts []int
+	baseOther, other := base.SampleValues, 0
+	for _, v := range base.TopValues {
+		known[v.Value] = true
+		baseCounts = append(baseCounts, v.Count)
+		counts = append(counts, valueMap[v.Value])
+		baseOther -= v.Count
+	}
+
+	var added []string
+	for _, v := range SortMap(valueMap, -1) {
+		if !known[v.X] {
+			other += v.Y
+			if !slices.Contains(base.Categories, v.X) {
+				added = append(added, v.X)
+			}
+		}
+	}
+
+	baseCounts, counts = append(baseCounts, baseOther), append(counts, other)
+	d.add(Metric{
+		Column:    base.Name,
+		Metric:    PSI_METRIC,
+		Baseline:  fmt.Sprintf("%d values", len(base.TopValues)),
+		Current:   fmt.Sprintf("%d values", len(valueMap)),
+		Value:     PSI(proportions(baseCounts), proportions(counts)),
+		Threshold: PSI_THRESHOLD,
+	})
+
+	baseValues := len(base.TopValues)
+	if len(base.Categories) > 0 {
+		baseValues = len(base.Categories)
+	} else if baseOther > 0 {
+		return
+	}
+	shown := added[:min(len(added), top)]
+	if len(added) > len(shown) {
+		shown = append(shown, fmt.Sprintf("%d more", len(added)-len(shown)))
+	}
+	if len(shown) == 0 {
+		shown = append(shown, "none")
+	}
+	d.add(Metric{
+		Column:   base.Name,
+		Metric:   NEW_CATEGORIES_METRIC,
+		Baseline: fmt.Sprintf("%d values", baseValues),
+		Current:  strings.Join(shown, ", "),
+		Value:    float64(len(added)),
+	})
+}
+
+func (d Drift) Write(writer io.Writer) error {
+	_, _ = fmt.Fprintf(writer, "-- DRIFT: `%s` against `%s` -- \n", d.File, d.Baseline)
+	_, _ = fmt.Fprintf(writer, "Rows: %d -> %d\n\n", d.BaselineRows, d.Rows)
+
+	result := utils.Result{
+		Headers: []utils.Header{
+			{Name: "column", Type: "VARCHAR"},
+			{Name: "metric", Type: "VARCHAR"},
+			{Name: "baseline", Type: "VARCHAR"},
+			{Name: "current", Type: "VARCHAR"},
+			{Name: "drift", Type: "DOUBLE"},
+			{Name: "threshold", Type: "DOUBLE"},
+			{Name: "status", Type: "VARCHAR"},
+		},
+	}
+	for _, m := range d.Metrics {
+		status := "OK"
+		if m.Drifted {
+			status = "DRIFT"
+		}
+		result.Rows = append(result.Rows, []any{
+			m.Column,
+			m.Metric,
+			m.Baseline,
+			m.Current,
+			math.Round(m.Value*1e4) / 1e4,
+			m.Threshold,
+			status,
+		})
+	}
+
+	if err := result.Render(writer, len(result.Rows)); err != nil {
+		return err
+	}
+
+	drifted := "none"
+	if len(d.Drifted) > 0 {
+		drifted = strings.Join(d.Drifted, ", ")
+	}
+	_, _ = fmt.Fprintf(writer, "\nDrifted: %s\n", drifted)
+	return nil
+}
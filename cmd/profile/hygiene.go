@@ -0,0 +1,359 @@
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"dct/cmd/utils"
+)
+
+const (
+	BOM_ISSUE                = "bom"
+	INVALID_UTF8_ISSUE       = "invalid_utf8"
+	NUL_BYTE_ISSUE           = "nul_byte"
+	MIXED_LINE_ENDINGS_ISSUE = "mixed_line_endings"
+	RAGGED_ROW_ISSUE         = "ragged_row"
+	STRAY_QUOTE_ISSUE        = "stray_quote"
+	UNBALANCED_QUOTE_ISSUE   = "unbalanced_quote"
+
+	LF   = "LF"
+	CRLF = "CRLF"
+	CR   = "CR"
+
+	// findings listed per issue, every occurrence is counted
+	MAX_FINDINGS = 10
+)
+
+var (
+	BOM          = []byte{0xEF, 0xBB, 0xBF}
+	LINE_ENDINGS = []string{LF, CRLF, CR}
+)
+
+// Finding locates an issue in the raw file, lines count from 1 and offsets
+// are bytes from the start of the file
+type Finding struct {
+	Issue  string `json:"issue"`
+	Line   int    `json:"line"`
+	Offset int64  `json:"offset"`
+	Detail string `json:"detail"`
+}
+
+// Hygiene describes problems in the raw bytes of a file that break loads
+// but are hidden once the file is parsed
+type Hygiene struct {
+	BOM         bool           `json:"bom"`
+	LineEndings map[string]int `json:"line_endings"`
+	FieldCounts map[int]int    `json:"field_counts,omitempty"`
+	Issues      map[string]int `json:"issues"`
+	Findings    []Finding      `json:"findings"`
+}
+
+func (h *Hygiene) add(f Finding) {
+	h.Issues[f.Issue]++
+	if h.Issues[f.Issue] <= MAX_FINDINGS {
+		h.Findings = append(h.Findings, f)
+	}
+}
+
+type position struct {
+	line   int
+	offset int64
+}
+
+// scanner walks the file a rune at a time, it follows csv quoting so line
+// breaks inside quoted fields do not end a row. Fields are only counted with
+// a delimiter of one character
+type scanner struct {
+	reader    *bufio.Reader
+	hygiene   Hygiene
+	isCSV     bool
+	delimiter rune
+
+	pos position
+	// first line ending of each kind, to point at the odd ones out
+	firstEnding map[string]position
+
+	inQuotes   bool
+	quoteStart position
+	fieldStart bool
+	fields     int
+	rowStart   position
+	rowEmpty   bool
+	header     int
+}
+
+func scanFile(file string) (Hygiene, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Hygiene{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	s := scanner{
+		reader:      bufio.NewReader(f),
+		isCSV:       strings.ToLower(path.Ext(file)) == utils.CSV,
+		pos:         position{line: 1},
+		firstEnding: make(map[string]position),
+		hygiene: Hygiene{
+			LineEndings: make(map[string]int),
+			Issues:      make(map[string]int),
+			Findings:    []Finding{},
+		},
+	}
+	if s.isCSV {
+		s.delimiter = sniffDelimiter(file)
+	}
+	if s.delimiter != 0 {
+		s.hygiene.FieldCounts = make(map[int]int)
+	}
+
+	if err := s.scan(); err != nil {
+		return Hygiene{}, err
+	}
+
+	return s.hygiene, nil
+}
+
+// sniffDelimiter is the delimiter duckdb detects, a comma when the file is
+// too broken to sniff and 0 when the delimiter is longer than one character
+func sniffDelimiter(file string) rune {
+	query := fmt.Sprintf("select Delimiter from sniff_csv('%s', ignore_errors = true)", strings.ReplaceAll(file, "'", "''"))
+	result, err := utils.Query(query)
+	if err != nil || len(result.Rows) == 0 {
+		return ','
+	}
+
+	delimiter, _ := result.Rows[0][0].(string)
+	if utf8.RuneCountInString(delimiter) != 1 {
+		return 0
+	}
+	r, _ := utf8.DecodeRuneInString(delimiter)
+	return r
+}
+
+func (s *scanner) scan() error {
+	if b, _ := s.reader.Peek(len(BOM)); slices.Equal(b, BOM) {
+		s.hygiene.BOM = true
+		s.hygiene.add(Finding{BOM_ISSUE, 1, 0, "UTF-8 byte order mark, the first column name may include it"})
+		_, _ = s.reader.Discard(len(BOM))
+		s.pos.offset += int64(len(BOM))
+	}
+
+	s.startRow()
+	for {
+		b, err := s.reader.Peek(utf8.UTFMax)
+		if len(b) == 0 {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		r, size := utf8.DecodeRune(b)
+		at := s.pos
+		_, _ = s.reader.Discard(size)
+		s.pos.offset += int64(size)
+
+		switch {
+		case r == utf8.RuneError && size == 1:
+			s.hygiene.add(Finding{INVALID_UTF8_ISSUE, at.line, at.offset, fmt.Sprintf("invalid UTF-8 byte 0x%02X", b[0])})
+			s.rowEmpty = false
+			s.fieldStart = false
+			continue
+		case r == 0:
+			s.hygiene.add(Finding{NUL_BYTE_ISSUE, at.line, at.offset, "NUL byte"})
+		}
+
+		if r == '\r' || r == '\n' {
+			s.lineEnding(r, at)
+			continue
+		}
+
+		if s.isCSV {
+			s.csv(r, at)
+		}
+	}
+
+	s.finish()
+	return nil
+}
+
+func (s *scanner) lineEnding(r rune, at position) {
+	ending := LF
+	if r == '\r' {
+		ending = CR
+		if next, _ := s.reader.Peek(1); len(next) == 1 && next[0] == '\n' {
+			ending = CRLF
+			_, _ = s.reader.Discard(1)
+			s.pos.offset++
+		}
+	}
+
+	s.hygiene.LineEndings[ending]++
+	if _, ok := s.firstEnding[ending]; !ok {
+		s.firstEnding[ending] = at
+	}
+	s.pos.line++
+
+	if s.isCSV && !s.inQuotes {
+		s.endRow()
+		s.startRow()
+	}
+}
+
+func (s *scanner) csv(r rune, at position) {
+	s.rowEmpty = false
+	switch {
+	case s.inQuotes && r == '"':
+		// a doubled quote is an escaped quote
+		if next, _ := s.reader.Peek(1); len(next) == 1 && next[0] == '"' {
+			_, _ = s.reader.Discard(1)
+			s.pos.offset++
+			return
+		}
+		s.inQuotes = false
+	case s.inQuotes:
+	case r == '"' && s.fieldStart:
+		s.inQuotes = true
+		s.quoteStart = at
+		s.fieldStart = false
+	case r == '"':
+		s.hygiene.add(Finding{STRAY_QUOTE_ISSUE, at.line, at.offset, "quote inside an unquoted field"})
+	case r == s.delimiter && s.delimiter != 0:
+		s.fields++
+		s.fieldStart = true
+	default:
+		s.fieldStart = false
+	}
+}
+
+func (s *scanner) startRow() {
+	s.fields = 1
+	s.fieldStart = true
+	s.rowStart = s.pos
+	s.rowEmpty = true
+}
+
+// endRow compares the fields of a row with the header, blank lines are skipped
+func (s *scanner) endRow() {
+	if s.rowEmpty || s.hygiene.FieldCounts == nil {
+		return
+	}
+
+	s.hygiene.FieldCounts[s.fields]++
+	if s.header == 0 {
+		s.header = s.fields
+		return
+	}
+
+	if s.fields != s.header {
+		s.hygiene.add(Finding{
+			RAGGED_ROW_ISSUE,
+			s.rowStart.line,
+			s.rowStart.offset,
+			fmt.Sprintf("%d fields, expected %d from the header", s.fields, s.header),
+		})
+	}
+}
+
+func (s *scanner) finish() {
+	if s.isCSV {
+		if s.inQuotes {
+			s.hygiene.add(Finding{
+				UNBALANCED_QUOTE_ISSUE,
+				s.quoteStart.line,
+				s.quoteStart.offset,
+				"quoted field is never closed, the rest of the file is read as one field",
+			})
+		}
+		s.endRow()
+	}
+
+	// the most common line ending is expected, the first of each other kind is
+	// pointed at
+	var endings []string
+	for _, ending := range LINE_ENDINGS {
+		if s.hygiene.LineEndings[ending] > 0 {
+			endings = append(endings, ending)
+		}
+	}
+	if len(endings) < 2 {
+		return
+	}
+
+	slices.SortStableFunc(endings, func(a, b string) int {
+		return s.hygiene.LineEndings[b] - s.hygiene.LineEndings[a]
+	})
+	for _, ending := range endings[1:] {
+		at := s.firstEnding[ending]
+		s.hygiene.add(Finding{
+			MIXED_LINE_ENDINGS_ISSUE,
+			at.line,
+			at.offset,
+			fmt.Sprintf("%d %s line endings, most lines end with %s", s.hygiene.LineEndings[ending], ending, endings[0]),
+		})
+	}
+}
+
+func (h Hygiene) String() string {
+	var s strings.Builder
+
+	var endings []string
+	for _, ending := range LINE_ENDINGS {
+		if n := h.LineEndings[ending]; n > 0 {
+			endings = append(endings, fmt.Sprintf("%s %d", ending, n))
+		}
+	}
+	if len(endings) == 0 {
+		endings = append(endings, "none")
+	}
+	fmt.Fprintf(&s, "BOM: %t\n", h.BOM)
+	fmt.Fprintf(&s, "Line Endings: %s\n", strings.Join(endings, ", "))
+
+	if h.FieldCounts != nil {
+		var counts []int
+		for n := range h.FieldCounts {
+			counts = append(counts, n)
+		}
+		slices.Sort(counts)
+
+		var fields []string
+		for _, n := range counts {
+			fields = append(fields, fmt.Sprintf("%d -> %d rows", n, h.FieldCounts[n]))
+		}
+		fmt.Fprintf(&s, "Fields per Row: %s\n", strings.Join(fields, ", "))
+	}
+
+	if len(h.Findings) == 0 {
+		s.WriteString("Findings: none")
+		return s.String()
+	}
+
+	var issues []string
+	for issue := range h.Issues {
+		issues = append(issues, issue)
+	}
+	slices.Sort(issues)
+
+	s.WriteString("Findings")
+	for _, issue := range issues {
+		n := h.Issues[issue]
+		fmt.Fprintf(&s, "\n%s: %d", issue, n)
+		if n > MAX_FINDINGS {
+			fmt.Fprintf(&s, " (showing %d)", MAX_FINDINGS)
+		}
+		for _, f := range h.Findings {
+			if f.Issue == issue {
+				fmt.Fprintf(&s, "\n  line %d (byte %d): %s", f.Line, f.Offset, f.Detail)
+			}
+		}
+	}
+
+	return s.String()
+}
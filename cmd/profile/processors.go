@@ -1,9 +1,13 @@
 package profile
 
 import (
+	"cmp"
 	"fmt"
-	"math"
-	"sort"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"unicode"
 
 	"dct/cmd/utils"
@@ -14,65 +18,74 @@ type Vec2[K, V comparable] struct {
 	Y V
 }
 
-func SortMap[K comparable](m map[K]int, dir int) []Vec2[K, int] {
+// SortMap orders map entries by count, descending when dir is negative, with
+// ties broken by key so the order is the same on every run
+func SortMap[K cmp.Ordered](m map[K]int, dir int) []Vec2[K, int] {
 	var arr []Vec2[K, int]
 	for k, v := range m {
 		arr = append(arr, Vec2[K, int]{k, v})
 	}
 
-	sort.Slice(arr, func(i, j int) bool {
-		return arr[i].Y > arr[j].Y
+	slices.SortFunc(arr, func(a, b Vec2[K, int]) int {
+		if a.Y != b.Y {
+			if dir < 0 {
+				return cmp.Compare(b.Y, a.Y)
+			}
+			return cmp.Compare(a.Y, b.Y)
+		}
+		return cmp.Compare(a.X, b.X)
 	})
 
 	return arr
 }
 
-type Summary struct {
-	Min   int
-	Mean  float64
-	Max   int
-	Count int
-	Sum   int
-}
+// CountValues counts the values of a sample column, nulls are left out as
+// they are counted over the whole file
+func CountValues(sample utils.Result, col int) map[string]int {
+	valueMap := make(map[string]int)
+	for _, row := range sample.Rows {
+		if row[col] == nil {
+			continue
+		}
+		valueMap[fmt.Sprintf("%v", row[col])]++
+	}
 
-func (s Summary) String() string {
-	return fmt.Sprintf("Min: %d\nMean: %f\nMax: %d", s.Min, s.Mean, s.Max)
+	return valueMap
 }
 
-func Summarise(m map[string]int) Summary {
-	var summary Summary
-	summary.Min = math.MaxInt
-	summary.Max = math.MinInt
-
-	for k := range m {
-		length := len(k)
-		summary.Count++
-		summary.Sum += length
-		if length > summary.Max {
-			summary.Max = length
-		}
-		if length < summary.Min {
-			summary.Min = length
+// CountRunes counts the runes of each distinct value
+func CountRunes(valueMap map[string]int) map[rune]int {
+	runeMap := make(map[rune]int)
+	for k := range valueMap {
+		for _, r := range k {
+			runeMap[r]++
 		}
 	}
 
-	utils.Assert(summary.Min < math.MaxInt, "error finding min string length")
-	utils.Assert(summary.Max > math.MinInt, "error finding max string length")
+	return runeMap
+}
 
-	summary.Mean = float64(summary.Sum) / float64(summary.Count)
+type Summary struct {
+	Min   int     `json:"min"`
+	Mean  float64 `json:"mean"`
+	Max   int     `json:"max"`
+	Count int     `json:"count"`
+	Sum   int     `json:"sum"`
+}
 
-	return summary
+func (s Summary) String() string {
+	return fmt.Sprintf("Min: %d\nMean: %f\nMax: %d", s.Min, s.Mean, s.Max)
 }
 
 type Analysis struct {
-	Control            int
-	Comma              int
-	Pipe               int
-	Quotes             int
-	Space              int
-	NonSpaceWhitespace int
-	NonASCII           int
-	Rest               int
+	Control            int `json:"control"`
+	Comma              int `json:"comma"`
+	Pipe               int `json:"pipe"`
+	Quotes             int `json:"quotes"`
+	Space              int `json:"space"`
+	NonSpaceWhitespace int `json:"non_space_whitespace"`
+	NonASCII           int `json:"non_ascii"`
+	Rest               int `json:"rest"`
 }
 
 func (a Analysis) String() string {
@@ -110,3 +123,147 @@ func AnalyseRunes(m map[rune]int) Analysis {
 
 	return analysis
 }
+
+const (
+	SHAPE_EXAMPLES = 3
+	// share of values matching a semantic type for a column to be that type
+	SEMANTIC_THRESHOLD = 0.9
+)
+
+type ShapeCount struct {
+	Shape    string   `json:"shape"`
+	Count    int      `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// Shape reduces a value to its pattern, letters become A, digits 9 and the
+// rest is kept so `abc-1234` is `AAA-9999`
+func Shape(s string) string {
+	var shape strings.Builder
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r):
+			shape.WriteRune('A')
+		case unicode.IsDigit(r):
+			shape.WriteRune('9')
+		default:
+			shape.WriteRune(r)
+		}
+	}
+
+	return shape.String()
+}
+
+// MineShapes counts the shapes of values, most frequent first, with the
+// first few values of each shape as examples
+func MineShapes(valueMap map[string]int, limit int) []ShapeCount {
+	shapeMap := make(map[string]int)
+	examples := make(map[string][]string)
+	for k, v := range valueMap {
+		shape := Shape(k)
+		shapeMap[shape] += v
+		examples[shape] = append(examples[shape], k)
+	}
+
+	var shapes []ShapeCount
+	for _, v := range SortMap(shapeMap, -1)[:min(limit, len(shapeMap))] {
+		values := examples[v.X]
+		slices.Sort(values)
+		shapes = append(shapes, ShapeCount{v.X, v.Y, values[:min(SHAPE_EXAMPLES, len(values))]})
+	}
+
+	return shapes
+}
+
+type SemanticCount struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+type semanticType struct {
+	name  string
+	match func(string) bool
+}
+
+func matches(pattern string) func(string) bool {
+	re := regexp.MustCompile(pattern)
+	return re.MatchString
+}
+
+// checked in order, the first match names the value
+var SEMANTIC_TYPES = []semanticType{
+	{"uuid", matches(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)},
+	{"email", matches(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)},
+	{"url", matches(`^(?i)(https?|ftp)://[^\s/$.?#].[^\s]*$`)},
+	{"ip", func(s string) bool { return net.ParseIP(s) != nil }},
+	{"iso_date", matches(`^\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?)?$`)},
+	{"phone", isPhone},
+}
+
+var (
+	phonePattern   = regexp.MustCompile(`^\+?[\d\s().-]{7,20}$`)
+	phoneSeparator = regexp.MustCompile(`\d[\s().-]+\d`)
+	datePattern    = regexp.MustCompile(`^\d{1,4}[-./]\d{1,2}[-./]\d{1,4}$`)
+)
+
+// isPhone needs at least 7 digits and a leading + or a separator between
+// groups of digits, numbers and dates are left out as digits alone are more
+// often ids and a leading - or decimal point makes a number
+func isPhone(s string) bool {
+	if _, err := strconv.ParseFloat(s, 64); err == nil || datePattern.MatchString(s) {
+		return false
+	}
+	if !phonePattern.MatchString(s) {
+		return false
+	}
+
+	digits := 0
+	for _, r := range s {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	return digits >= 7 && (strings.HasPrefix(s, "+") || phoneSeparator.MatchString(s))
+}
+
+func semanticTypeOf(s string) string {
+	for _, t := range SEMANTIC_TYPES {
+		if t.match(s) {
+			return t.name
+		}
+	}
+
+	return ""
+}
+
+// DetectSemantic counts the values matching each semantic type, types with
+// no matches are left out
+func DetectSemantic(valueMap map[string]int) []SemanticCount {
+	counts := make(map[string]int)
+	for k, v := range valueMap {
+		if name := semanticTypeOf(strings.TrimSpace(k)); name != "" {
+			counts[name] += v
+		}
+	}
+
+	var semantic []SemanticCount
+	for _, t := range SEMANTIC_TYPES {
+		if counts[t.name] > 0 {
+			semantic = append(semantic, SemanticCount{t.name, counts[t.name]})
+		}
+	}
+
+	return semantic
+}
+
+// SemanticTypeOf names the type most values match when it is shared by at
+// least SEMANTIC_THRESHOLD of them
+func SemanticTypeOf(semantic []SemanticCount, total int) string {
+	for _, s := range semantic {
+		if total > 0 && float64(s.Count)/float64(total) >= SEMANTIC_THRESHOLD {
+			return s.Type
+		}
+	}
+
+	return ""
+}
@@ -1,6 +1,7 @@
 package profile
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -18,11 +19,27 @@ import (
 var (
 	defaultWriter = os.Stdout
 	output        string
+	format        string
+	top           int
+	relations     bool
+	save          string
+	against       string
+	selection     Selection
 	writer        io.Writer
 )
 
 func init() {
 	ProfileCmd.Flags().StringVarP(&output, "output", "o", "", "Output file path (default: stdout)")
+	ProfileCmd.Flags().StringVarP(&format, "format", "f", TEXT_FORMAT, "Output format supports text, json, html")
+	ProfileCmd.Flags().IntVarP(&top, "top", "t", TOP_VALUES, "Number of most frequent values to show per field")
+	ProfileCmd.Flags().BoolVarP(&relations, "relationships", "r", false,
+		"Find candidate keys, functional dependencies and correlations between columns instead")
+	ProfileCmd.Flags().StringVar(&save, "save", "", "Save the profile as a json baseline to compare later runs against")
+	ProfileCmd.Flags().StringVar(&against, "against", "", "Compare with a baseline saved by --save instead, flagging drifted columns")
+	ProfileCmd.Flags().StringSliceVarP(&selection.Columns, "columns", "c", nil, "Only profile these columns e.g. a,b")
+	ProfileCmd.Flags().StringSliceVarP(&selection.Exclude, "exclude", "x", nil, "Profile every column except these e.g. a,b")
+	ProfileCmd.Flags().StringVarP(&selection.Sample, "sample", "s", "", "Only profile a sample of rows, a number of rows or a percentage e.g. 1000 or 10%")
+	ProfileCmd.Flags().StringVarP(&selection.Where, "where", "w", "", "Only profile rows matching a sql condition e.g. \"amount > 0\"")
 }
 
 var ProfileCmd = &cobra.Command{
@@ -32,6 +49,13 @@ var ProfileCmd = &cobra.Command{
 	Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
 	Run: func(cmd *cobra.Command, args []string) {
 		file := parseFileArg(args)
+		if !slices.Contains(FORMATS, format) {
+			log.Fatalf("Error: unsupported format: %s, expected one of %v\n", format, FORMATS)
+		}
+		if top < 1 {
+			log.Printf("Warning: expected --top to be at least 1 defaulting to %d\n", TOP_VALUES)
+			top = TOP_VALUES
+		}
 
 		var err error
 		writer = defaultWriter
@@ -42,13 +66,22 @@ var ProfileCmd = &cobra.Command{
 			}
 		}
 
-		query := fmt.Sprintf("select * from '%s'", file)
-		result, err := utils.Query(query)
+		if against != "" && format == HTML_FORMAT {
+			log.Fatalf("Error: --against supports text and json formats, not %s\n", format)
+		}
+
+		relation, cleanup, err := selection.Relation(file)
 		if err != nil {
-			log.Fatalf("failed to read file: %v", err)
+			log.Fatalf("Error: %v\n", err)
 		}
+		defer cleanup()
 
-		analyse(result, writer)
+		if relations {
+			profileRelationships(relation, writer)
+			return
+		}
+
+		profile(file, relation, writer)
 	},
 }
 
@@ -69,72 +102,222 @@ func parseFileArg(args []string) string {
 	return ""
 }
 
-func analyse(result utils.Result, writer io.Writer) {
-	_, _ = fmt.Fprintln(writer, "-- PROFILE -- ")
+func profile(file string, relation string, writer io.Writer) {
+	// the raw file is scanned first so its problems are reported even when
+	// duckdb fails to read it, a sample or filter of rows profiles a part of
+	// the file so the whole of it isn't scanned
+	var hygiene *Hygiene
+	if strings.ToLower(path.Ext(file)) != utils.PARQUET && selection.Sample == "" && selection.Where == "" {
+		h, err := scanFile(file)
+		if err != nil {
+			log.Fatalf("failed to scan file: %v", err)
+		}
+		hygiene = &h
+	}
 
-	for i := range len(result.Headers) {
-		var col []string
-		for _, row := range result.Rows {
-			col = append(col, fmt.Sprintf("%v", row[i]))
+	if format == TEXT_FORMAT && against == "" {
+		_, _ = fmt.Fprintln(writer, "-- PROFILE -- ")
+		if hygiene != nil {
+			_, _ = fmt.Fprintf(writer, "-- File: `%s` -- \n%s\n\n", file, hygiene)
+		}
+		if !selection.IsEmpty() {
+			_, _ = fmt.Fprintf(writer, "-- Selection: %s -- \n\n", selection)
 		}
-		// writes directly to ouput
-		analyseField(result.Headers[i].Name, col, writer)
 	}
-}
 
-func analyseField(header string, column []string, writer io.Writer) {
-	_, _ = fmt.Fprintf(writer, "-- Field: `%s` -- \n", header)
+	headers, err := describe(relation)
+	if err != nil {
+		log.Fatalf("failed to read file: %v", err)
+	}
+
+	profiles, err := summariseColumns(relation, headers)
+	if err != nil {
+		log.Fatalf("failed to profile file: %v", err)
+	}
 
-	valueMap := make(map[string]int)
-	for _, str := range column {
-		valueMap[str]++
+	// value and char analysis is bounded to a sample of rows
+	query := fmt.Sprintf(
+		"select * from %s using sample reservoir(%d rows) repeatable (%d)",
+		relation,
+		SAMPLE_ROWS,
+		SAMPLE_SEED,
+	)
+	sample, err := utils.Query(query)
+	if err != nil {
+		log.Fatalf("failed to sample file: %v", err)
 	}
 
-	_, _ = fmt.Fprintf(writer, "Count: %d\nUnique Count: %d\n\n", len(column), len(valueMap))
-	_, _ = fmt.Fprintln(writer, "Value Occurrence")
+	if format == TEXT_FORMAT && save == "" && against == "" {
+		analyse(profiles, sample, writer)
+		return
+	}
 
-	i := 0
-	// mostly unique values, just sample 10
-	if len(valueMap) >= len(column)>>1 {
-		_, _ = fmt.Fprintln(writer, "MOSTLY UNIQUE VALUES SHOWING SAMPLE...")
-		_, _ = fmt.Fprintln(writer, "row: value -> count")
-		for k, v := range valueMap {
-			if i > 10 {
-				break
-			}
-			_, _ = fmt.Fprintf(writer, "%d: %v -> %d\n", i, k, v)
-			i++
+	report, err := buildReport(file, relation, profiles, sample)
+	if err != nil {
+		log.Fatalf("failed to build report: %v", err)
+	}
+	report.Hygiene = hygiene
+	if !selection.IsEmpty() {
+		report.Selection = &selection
+	}
+
+	if save != "" {
+		if err := saveReport(save, report); err != nil {
+			log.Fatalf("Error: failed to save profile: %v\n", err)
 		}
+	}
+
+	if against != "" {
+		profileDrift(report, relation, sample, writer)
+		return
+	}
+
+	switch format {
+	case TEXT_FORMAT:
+		analyse(profiles, sample, writer)
+	case JSON_FORMAT:
+		err = report.WriteJSON(writer)
+	default:
+		err = report.WriteHTML(writer)
+	}
+	if err != nil {
+		log.Fatalf("failed to write report: %v", err)
+	}
+}
+
+func profileDrift(report ProfileReport, relation string, sample utils.Result, writer io.Writer) {
+	baseline, err := readBaseline(against)
+	if err != nil {
+		log.Fatalf("Error: failed to read baseline %s: %v\n", against, err)
+	}
+
+	// columns left out on purpose have not gone missing
+	baseline.Columns = slices.DeleteFunc(baseline.Columns, func(c ColumnReport) bool {
+		return selection.Skips(c.Name)
+	})
+
+	d, err := compare(baseline, report, relation, sample)
+	if err != nil {
+		log.Fatalf("failed to compare profiles: %v", err)
+	}
+
+	if format == JSON_FORMAT {
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(d)
 	} else {
-		_, _ = fmt.Fprintln(writer, "row: value -> count")
-		for k, v := range valueMap {
-			_, _ = fmt.Fprintf(writer, "%d: %v -> %d\n", i, k, v)
-			i++
-		}
+		err = d.Write(writer)
+	}
+	if err != nil {
+		log.Fatalf("failed to write drift: %v", err)
 	}
-	_, _ = fmt.Fprintln(writer)
 
-	_, _ = fmt.Fprint(writer, "Value Summary - String Lengths\n")
-	_, _ = fmt.Fprintf(writer, "%s\n\n", Summarise(valueMap))
+	if len(d.Drifted) > 0 {
+		log.Printf("%d columns drifted from %s\n", len(d.Drifted), against)
+		os.Exit(1)
+	}
+}
 
-	runeMap := make(map[rune]int)
-	for k := range valueMap {
-		for _, r := range k {
-			runeMap[r]++
-		}
+func analyse(profiles []ColumnProfile, sample utils.Result, writer io.Writer) {
+	for i, p := range profiles {
+		// writes directly to ouput
+		analyseField(p, CountValues(sample, i), len(sample.Rows), writer)
 	}
+}
+
+func analyseField(p ColumnProfile, valueMap map[string]int, sampleRows int, writer io.Writer) {
+	_, _ = fmt.Fprintf(writer, "-- Field: `%s` -- \n", p.Name)
+	_, _ = fmt.Fprintf(writer, "%s\n", p)
+
+	_, _ = fmt.Fprintf(
+		writer,
+		"Top %d Values (sample of %d rows, %d distinct)\n",
+		top,
+		sampleRows,
+		len(valueMap),
+	)
+
+	nonNull := 0
+	for _, v := range valueMap {
+		nonNull += v
+	}
+	if len(valueMap) > 1 && len(valueMap) >= nonNull>>1 {
+		_, _ = fmt.Fprintln(writer, "MOSTLY UNIQUE VALUES")
+	}
+
+	_, _ = fmt.Fprintln(writer, "row: value -> count")
+	for i, v := range topValues(valueMap, top) {
+		_, _ = fmt.Fprintf(writer, "%d: %v -> %d\n", i, v.Value, v.Count)
+	}
+	_, _ = fmt.Fprintln(writer)
+
+	// the summaries shown depend on the column type
+	switch {
+	case p.Numeric != nil:
+		_, _ = fmt.Fprintf(writer, "Numeric Summary\n%s\n\n", p.Numeric)
+	case p.Temporal != nil:
+		_, _ = fmt.Fprintf(writer, "Date Summary\n%s\n\n", p.Temporal)
+	case p.Boolean != nil:
+		_, _ = fmt.Fprintf(writer, "Boolean Summary\n%s\n\n", p.Boolean.String(p.Nulls))
+	case p.Lengths != nil:
+		_, _ = fmt.Fprint(writer, "Value Summary - String Lengths\n")
+		_, _ = fmt.Fprintf(writer, "%s\n\n", p.Lengths)
+		analyseShapes(valueMap, nonNull, sampleRows, writer)
+	}
+
+	runeMap := CountRunes(valueMap)
 
 	var runes strings.Builder
 	runes.WriteString("row: rune -> count\n")
-	leading := int(math.Ceil(math.Log10(float64(len(runeMap)))))
+	leading := int(math.Ceil(math.Log10(float64(max(len(runeMap), 1)))))
 
-	i = 0
-	for k, v := range runeMap {
+	for i, v := range SortMap(runeMap, -1) {
 		fmt.Fprintf(&runes, "%0*d: %[3]q (hex: %[3]U) (dec: %[3]d) -> %[4]d\n",
-			leading, i, k, v)
-		i++
+			leading, i, v.X, v.Y)
 	}
 
-	_, _ = fmt.Fprintf(writer, "Char Occurrence\n%s\n", runes.String())
+	_, _ = fmt.Fprintf(writer, "Char Occurrence (sample of %d rows)\n%s\n", sampleRows, runes.String())
 	_, _ = fmt.Fprintf(writer, "Char Analysis\n%s\n\n", AnalyseRunes(runeMap))
 }
+
+func analyseShapes(valueMap map[string]int, nonNull int, sampleRows int, writer io.Writer) {
+	_, _ = fmt.Fprintf(writer, "Shapes (sample of %d rows)\n", sampleRows)
+	_, _ = fmt.Fprintln(writer, "row: shape -> count (examples)")
+	for i, s := range MineShapes(valueMap, top) {
+		var examples []string
+		for _, e := range s.Examples {
+			examples = append(examples, fmt.Sprintf("%q", e))
+		}
+		_, _ = fmt.Fprintf(writer, "%d: %q -> %d (%s)\n", i, s.Shape, s.Count, strings.Join(examples, ", "))
+	}
+	_, _ = fmt.Fprintln(writer)
+
+	semantic := DetectSemantic(valueMap)
+	_, _ = fmt.Fprintf(writer, "Semantic Types (sample of %d rows)\n", sampleRows)
+	if len(semantic) == 0 {
+		_, _ = fmt.Fprintln(writer, "none")
+	}
+	for _, s := range semantic {
+		_, _ = fmt.Fprintf(writer, "%s: %d (%.2f%%)\n", s.Type, s.Count, ratio(s.Count, nonNull))
+	}
+	if t := SemanticTypeOf(semantic, nonNull); t != "" {
+		_, _ = fmt.Fprintf(writer, "Detected: %s\n", t)
+	}
+	_, _ = fmt.Fprintln(writer)
+}
+
+func profileRelationships(relation string, writer io.Writer) {
+	r := relationships(relation)
+	switch format {
+	case TEXT_FORMAT:
+		r.Write(writer, top)
+	case JSON_FORMAT:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(r); err != nil {
+			log.Fatalf("failed to write relationships: %v", err)
+		}
+	default:
+		log.Fatalf("Error: --relationships supports text and json formats, not %s\n", format)
+	}
+}
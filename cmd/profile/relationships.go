@@ -0,0 +1,430 @@
+package profile
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"strings"
+
+	"dct/cmd/utils"
+)
+
+const (
+	// combinations grow quickly so relationships are only searched for
+	// between the first columns and keys of up to three columns
+	MAX_RELATIONSHIP_COLUMNS = 20
+	MAX_KEY_SIZE             = 3
+
+	// candidates found in the sample are each counted over every row, so
+	// only this many are verified
+	MAX_KEY_CANDIDATES        = 50
+	MAX_DEPENDENCY_CANDIDATES = 100
+)
+
+type Relationships struct {
+	Rows          int           `json:"rows"`
+	SampleRows    int           `json:"sample_rows"`
+	CandidateKeys [][]string    `json:"candidate_keys"`
+	Dependencies  []Dependency  `json:"functional_dependencies"`
+	Correlations  []Correlation `json:"correlations"`
+}
+
+type Dependency struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Correlation struct {
+	Left    string  `json:"left"`
+	Right   string  `json:"right"`
+	Pearson float64 `json:"pearson"`
+}
+
+// aggregator names each aggregate once so shared ones are computed once
+type aggregator struct {
+	exprs   []string
+	aliases map[string]string
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{aliases: make(map[string]string)}
+}
+
+func (a *aggregator) add(expr string) string {
+	if alias, ok := a.aliases[expr]; ok {
+		return alias
+	}
+
+	alias := fmt.Sprintf("agg_%d", len(a.exprs))
+	a.aliases[expr] = alias
+	a.exprs = append(a.exprs, fmt.Sprintf("%s as %s", expr, alias))
+	return alias
+}
+
+func (a *aggregator) query(setup string, relation string) (map[string]any, error) {
+	exprs := append([]string{"count(*) as agg_rows"}, a.exprs...)
+	result, err := utils.Query(fmt.Sprintf("%sselect %s from %s", setup, strings.Join(exprs, ", "), relation))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]any)
+	for i, header := range result.Headers {
+		values[header.Name] = result.Rows[0][i]
+	}
+	return values, nil
+}
+
+func quoteAll(cols []string) []string {
+	var quoted []string
+	for _, col := range cols {
+		quoted = append(quoted, utils.QuoteIdent(col))
+	}
+	return quoted
+}
+
+// distinctSQL counts distinct combinations of columns, nulls are values
+func distinctSQL(cols ...string) string {
+	return fmt.Sprintf("count(distinct row(%s))", strings.Join(quoteAll(cols), ", "))
+}
+
+// keySQL counts distinct combinations without nulls, a key has one per row
+func keySQL(cols []string) string {
+	var notNull []string
+	for _, col := range quoteAll(cols) {
+		notNull = append(notNull, col+" is not null")
+	}
+	return fmt.Sprintf("%s filter (where %s)", distinctSQL(cols...), strings.Join(notNull, " and "))
+}
+
+func combinations(n, k int) [][]int {
+	if k == 0 {
+		return [][]int{{}}
+	}
+
+	var combos [][]int
+	for i := k - 1; i < n; i++ {
+		for _, c := range combinations(i, k-1) {
+			combos = append(combos, append(c, i))
+		}
+	}
+	return combos
+}
+
+func asInt(v any) int {
+	n, _ := v.(int)
+	return n
+}
+
+// sampleColumns holds the sample as text so combinations of columns can be
+// counted in memory, nulls are kept apart from the text "<nil>"
+type sampleColumns struct {
+	values [][]string
+	nulls  [][]bool
+	rows   int
+}
+
+func newSampleColumns(sample utils.Result, cols int) sampleColumns {
+	s := sampleColumns{
+		values: make([][]string, cols),
+		nulls:  make([][]bool, cols),
+		rows:   len(sample.Rows),
+	}
+	for _, row := range sample.Rows {
+		for i := range cols {
+			s.values[i] = append(s.values[i], fmt.Sprintf("%v", row[i]))
+			s.nulls[i] = append(s.nulls[i], row[i] == nil)
+		}
+	}
+	return s
+}
+
+// distinct counts the distinct combinations of columns, rows with a null
+// in any of them are skipped when skipNulls is set
+func (s sampleColumns) distinct(combo []int, skipNulls bool) int {
+	seen := make(map[string]struct{})
+	var key strings.Builder
+	for r := range s.rows {
+		key.Reset()
+		skip := false
+		for _, i := range combo {
+			if s.nulls[i][r] {
+				skip = skipNulls
+				key.WriteString("\x00null")
+			} else {
+				key.WriteString(s.values[i][r])
+			}
+			key.WriteByte(0x1f)
+		}
+		if !skip {
+			seen[key.String()] = struct{}{}
+		}
+	}
+	return len(seen)
+}
+
+// discover finds minimal candidate keys and single column functional
+// dependencies in a sample of rows then verifies them on every row. Keys are
+// searched by size and only supersets of verified keys are left out, a key of
+// the sample that doesn't hold for every row may still be part of a larger one
+func discover(relation string, cols []string) (rows int, keys [][]string, deps []Dependency, sampleRows int, err error) {
+	query := fmt.Sprintf(
+		"select %s from %s using sample reservoir(%d rows) repeatable (%d)",
+		strings.Join(quoteAll(cols), ", "),
+		relation,
+		SAMPLE_ROWS,
+		SAMPLE_SEED,
+	)
+	result, err := utils.Query(query)
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+
+	sample := newSampleColumns(result, len(cols))
+	if sample.rows == 0 {
+		return 0, nil, nil, 0, nil
+	}
+
+	distinct := make([]int, len(cols))
+	for i := range cols {
+		distinct[i] = sample.distinct([]int{i}, false)
+	}
+
+	var keyIndexes [][]int
+	candidates := 0
+	for size := 1; size <= MAX_KEY_SIZE && candidates < MAX_KEY_CANDIDATES; size++ {
+		var found [][]int
+		for _, combo := range combinations(len(cols), size) {
+			// too few values between them to be unique
+			product := 1
+			for _, i := range combo {
+				product = min(product*distinct[i], sample.rows)
+			}
+			if product < sample.rows || containsKeyIndex(combo, keyIndexes) {
+				continue
+			}
+
+			if sample.distinct(combo, true) == sample.rows {
+				found = append(found, combo)
+			}
+		}
+
+		if candidates+len(found) > MAX_KEY_CANDIDATES {
+			log.Printf("Warning: only %d candidate keys are verified, narrow the columns with --columns to verify others\n", MAX_KEY_CANDIDATES)
+			found = found[:MAX_KEY_CANDIDATES-candidates]
+		}
+		candidates += len(found)
+
+		verified, err := verifyKeys(relation, names(cols, found))
+		if err != nil {
+			return 0, nil, nil, 0, err
+		}
+		for i, combo := range found {
+			if verified[i] {
+				keyIndexes = append(keyIndexes, combo)
+				keys = append(keys, names(cols, [][]int{combo})[0])
+			}
+		}
+	}
+
+	for from := range cols {
+		if containsKeyIndex([]int{from}, keyIndexes) {
+			continue
+		}
+		for to := range cols {
+			// everything determines a constant
+			if from == to || distinct[to] < 2 {
+				continue
+			}
+			if sample.distinct([]int{from, to}, false) == distinct[from] {
+				deps = append(deps, Dependency{cols[from], cols[to]})
+			}
+		}
+	}
+	if len(deps) > MAX_DEPENDENCY_CANDIDATES {
+		log.Printf("Warning: only %d of %d candidate dependencies are verified, narrow the columns with --columns to verify others\n", MAX_DEPENDENCY_CANDIDATES, len(deps))
+		deps = deps[:MAX_DEPENDENCY_CANDIDATES]
+	}
+
+	rows, deps, err = verifyDependencies(relation, deps)
+	if err != nil {
+		return 0, nil, nil, 0, err
+	}
+	return rows, keys, deps, sample.rows, nil
+}
+
+func names(cols []string, combos [][]int) [][]string {
+	var named [][]string
+	for _, combo := range combos {
+		var key []string
+		for _, i := range combo {
+			key = append(key, cols[i])
+		}
+		named = append(named, key)
+	}
+	return named
+}
+
+func containsKeyIndex(combo []int, keys [][]int) bool {
+	for _, key := range keys {
+		if !slices.ContainsFunc(key, func(i int) bool { return !slices.Contains(combo, i) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyKeys checks which keys found in the sample are unique in every row
+// of the file
+func verifyKeys(relation string, keys [][]string) ([]bool, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	agg := newAggregator()
+	for _, key := range keys {
+		agg.add(keySQL(key))
+	}
+
+	values, err := agg.query("", relation)
+	if err != nil {
+		return nil, err
+	}
+	rows := asInt(values["agg_rows"])
+
+	var verified []bool
+	for _, key := range keys {
+		verified = append(verified, asInt(values[agg.add(keySQL(key))]) == rows)
+	}
+	return verified, nil
+}
+
+// verifyDependencies keeps the dependencies found in the sample that hold for
+// every row of the file
+func verifyDependencies(relation string, deps []Dependency) (int, []Dependency, error) {
+	agg := newAggregator()
+	for _, dep := range deps {
+		agg.add(distinctSQL(dep.From))
+		agg.add(distinctSQL(dep.From, dep.To))
+	}
+
+	values, err := agg.query("", relation)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var verified []Dependency
+	for _, dep := range deps {
+		if values[agg.add(distinctSQL(dep.From))] == values[agg.add(distinctSQL(dep.From, dep.To))] {
+			verified = append(verified, dep)
+		}
+	}
+	return asInt(values["agg_rows"]), verified, nil
+}
+
+func correlate(relation string, headers []utils.Header) ([]Correlation, error) {
+	var numeric []string
+	for _, header := range headers {
+		if isNumeric(header.Type) {
+			numeric = append(numeric, header.Name)
+		}
+	}
+	if len(numeric) < 2 {
+		return nil, nil
+	}
+
+	agg := newAggregator()
+	pairs := combinations(len(numeric), 2)
+	for _, pair := range pairs {
+		left, right := utils.QuoteIdent(numeric[pair[0]]), utils.QuoteIdent(numeric[pair[1]])
+		agg.add(fmt.Sprintf("corr(%s::double, %s::double)", left, right))
+	}
+
+	values, err := agg.query("", relation)
+	if err != nil {
+		return nil, err
+	}
+
+	var correlations []Correlation
+	for i, pair := range pairs {
+		// constant columns have no correlation
+		r, ok := values[fmt.Sprintf("agg_%d", i)].(float64)
+		if !ok || math.IsNaN(r) {
+			continue
+		}
+		correlations = append(correlations, Correlation{numeric[pair[0]], numeric[pair[1]], r})
+	}
+
+	slices.SortStableFunc(correlations, func(a, b Correlation) int {
+		return cmp.Compare(math.Abs(b.Pearson), math.Abs(a.Pearson))
+	})
+	return correlations, nil
+}
+
+func relationships(relation string) Relationships {
+	headers, err := describe(relation)
+	if err != nil {
+		log.Fatalf("failed to read file: %v", err)
+	}
+
+	var cols []string
+	for _, header := range headers {
+		cols = append(cols, header.Name)
+	}
+	if len(cols) > MAX_RELATIONSHIP_COLUMNS {
+		log.Printf("Warning: only the first %d of %d columns are searched for keys and dependencies, pick others with --columns\n", MAX_RELATIONSHIP_COLUMNS, len(cols))
+		cols = cols[:MAX_RELATIONSHIP_COLUMNS]
+	}
+
+	rows, keys, deps, sampleRows, err := discover(relation, cols)
+	if err != nil {
+		log.Fatalf("failed to discover relationships: %v", err)
+	}
+
+	correlations, err := correlate(relation, headers[:len(cols)])
+	if err != nil {
+		log.Fatalf("failed to correlate columns: %v", err)
+	}
+
+	return Relationships{
+		Rows:          rows,
+		SampleRows:    sampleRows,
+		CandidateKeys: keys,
+		Dependencies:  deps,
+		Correlations:  correlations,
+	}
+}
+
+func (r Relationships) Write(writer io.Writer, limit int) {
+	_, _ = fmt.Fprintln(writer, "-- RELATIONSHIPS -- ")
+	_, _ = fmt.Fprintf(writer, "Rows: %d (found in a sample of %d rows, verified on every row)\n\n", r.Rows, r.SampleRows)
+
+	_, _ = fmt.Fprintln(writer, "Candidate Keys (as diff keys)")
+	if len(r.CandidateKeys) == 0 {
+		_, _ = fmt.Fprintf(writer, "none of up to %d columns\n", MAX_KEY_SIZE)
+	}
+	for i, key := range r.CandidateKeys {
+		_, _ = fmt.Fprintf(writer, "%d: %s\n", i, strings.Join(key, ","))
+	}
+	_, _ = fmt.Fprintln(writer)
+
+	_, _ = fmt.Fprintln(writer, "Functional Dependencies")
+	if len(r.Dependencies) == 0 {
+		_, _ = fmt.Fprintln(writer, "none")
+	}
+	for _, dep := range r.Dependencies {
+		_, _ = fmt.Fprintf(writer, "%s -> %s\n", dep.From, dep.To)
+	}
+	_, _ = fmt.Fprintln(writer)
+
+	_, _ = fmt.Fprintf(writer, "Correlations (pearson, top %d)\n", limit)
+	if len(r.Correlations) == 0 {
+		_, _ = fmt.Fprintln(writer, "none")
+	}
+	for _, c := range r.Correlations[:min(limit, len(r.Correlations))] {
+		_, _ = fmt.Fprintf(writer, "%s, %s: %f\n", c.Left, c.Right, c.Pearson)
+	}
+}
@@ -0,0 +1,339 @@
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"slices"
+
+	"dct/cmd/utils"
+)
+
+const (
+	TEXT_FORMAT = "text"
+	JSON_FORMAT = "json"
+	HTML_FORMAT = "html"
+
+	HISTOGRAM_BINS = 10
+	TOP_VALUES     = 10
+)
+
+var FORMATS = []string{TEXT_FORMAT, JSON_FORMAT, HTML_FORMAT}
+
+// ProfileReport is the structured form of a profile, so profiles can be
+// diffed across runs or shared as a html page
+type ProfileReport struct {
+	File       string         `json:"file"`
+	Rows       int            `json:"rows"`
+	SampleRows int            `json:"sample_rows"`
+	Selection  *Selection     `json:"selection,omitempty"`
+	Hygiene    *Hygiene       `json:"hygiene,omitempty"`
+	Columns    []ColumnReport `json:"columns"`
+}
+
+type ColumnReport struct {
+	ColumnProfile
+	SampleValues  int             `json:"sample_values"`
+	TopValues     []ValueCount    `json:"top_values"`
+	Histogram     []Bin           `json:"histogram,omitempty"`
+	Shapes        []ShapeCount    `json:"shapes,omitempty"`
+	SemanticTypes []SemanticCount `json:"semantic_types,omitempty"`
+	SemanticType  string          `json:"semantic_type,omitempty"`
+	Chars         Analysis        `json:"chars"`
+	// every value of the sample, saved in baselines for new categories
+	Categories []string `json:"categories,omitempty"`
+}
+
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Bin is labelled for reading, Start and End are the exact edges binned on so
+// later runs can be binned the same way
+type Bin struct {
+	Lower string  `json:"lower"`
+	Upper string  `json:"upper"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Count int     `json:"count"`
+}
+
+// generateHistogramSQL buckets a numeric or temporal column into equal width
+// bins, temporal columns are binned on their epoch
+func generateHistogramSQL(relation string, header utils.Header, bins int) string {
+	col := utils.QuoteIdent(header.Name)
+	value := col + "::double"
+	label := "round(%s, 4)::varchar"
+	if isTemporal(header.Type) {
+		value = fmt.Sprintf("epoch(%s)", col)
+		label = "make_timestamp((%s * 1e6)::bigint)::varchar"
+		if header.Type == "DATE" {
+			label = "make_timestamp((%s * 1e6)::bigint)::date::varchar"
+		}
+	}
+
+	return fmt.Sprintf(
+		`with vals as (
+  select %[2]s as v from %[1]s where %[3]s is not null
+), bounds as (
+  select min(v) as lo, (max(v) - min(v)) / %[4]d as width from vals
+), counts as (
+  select coalesce(least(floor((v - lo) / nullif(width, 0)), %[4]d - 1), 0)::bigint as bin, count(*) as cnt
+  from vals, bounds
+  group by all
+)
+select
+  %[5]s as lower,
+  %[6]s as upper,
+  lo + bin * width as start,
+  lo + (bin + 1) * width as "end",
+  coalesce(cnt, 0) as cnt
+from range(%[4]d) as r(bin)
+cross join bounds
+left join counts using (bin)
+where lo is not null
+order by bin`,
+		relation,
+		value,
+		col,
+		bins,
+		fmt.Sprintf(label, "(lo + bin * width)"),
+		fmt.Sprintf(label, "(lo + (bin + 1) * width)"),
+	)
+}
+
+func histogram(relation string, header utils.Header) ([]Bin, error) {
+	result, err := utils.Query(generateHistogramSQL(relation, header, HISTOGRAM_BINS))
+	if err != nil {
+		return nil, err
+	}
+
+	var bins []Bin
+	for _, row := range result.Rows {
+		lower, _ := row[0].(string)
+		upper, _ := row[1].(string)
+		start, _ := row[2].(float64)
+		end, _ := row[3].(float64)
+		count, _ := row[4].(int)
+		bins = append(bins, Bin{lower, upper, start, end, count})
+	}
+
+	return bins, nil
+}
+
+// topValues orders values by count then value so reports are stable
+func topValues(valueMap map[string]int, limit int) []ValueCount {
+	var values []ValueCount
+	for _, v := range SortMap(valueMap, -1)[:min(limit, len(valueMap))] {
+		values = append(values, ValueCount{v.X, v.Y})
+	}
+
+	return values
+}
+
+func buildReport(file string, relation string, profiles []ColumnProfile, sample utils.Result) (ProfileReport, error) {
+	report := ProfileReport{File: file, SampleRows: len(sample.Rows)}
+	if len(profiles) > 0 {
+		report.Rows = profiles[0].Count
+	}
+
+	for i, p := range profiles {
+		valueMap := CountValues(sample, i)
+		nonNull := 0
+		for _, v := range valueMap {
+			nonNull += v
+		}
+		column := ColumnReport{
+			ColumnProfile: p,
+			SampleValues:  nonNull,
+			TopValues:     topValues(valueMap, top),
+			Chars:         AnalyseRunes(CountRunes(valueMap)),
+		}
+
+		if isText(p.Type) {
+			column.Shapes = MineShapes(valueMap, top)
+			column.SemanticTypes = DetectSemantic(valueMap)
+			column.SemanticType = SemanticTypeOf(column.SemanticTypes, nonNull)
+		}
+
+		if isNumeric(p.Type) || isTemporal(p.Type) {
+			bins, err := histogram(relation, utils.Header{Name: p.Name, Type: p.Type})
+			if err != nil {
+				return ProfileReport{}, fmt.Errorf("failed to bin %s: %v", p.Name, err)
+			}
+			column.Histogram = bins
+		}
+
+		if save != "" && len(column.Histogram) == 0 {
+			column.Categories = categories(valueMap)
+		}
+
+		report.Columns = append(report.Columns, column)
+	}
+
+	return report, nil
+}
+
+func (r ProfileReport) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r ProfileReport) WriteHTML(writer io.Writer) error {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"pct": func(n int, counts []int) float64 {
+			top := slices.Max(append(counts, 1))
+			return 100 * float64(n) / float64(top)
+		},
+		"binCounts": func(bins []Bin) []int {
+			var counts []int
+			for _, bin := range bins {
+				counts = append(counts, bin.Count)
+			}
+			return counts
+		},
+		"shapeCounts": func(shapes []ShapeCount) []int {
+			var counts []int
+			for _, shape := range shapes {
+				counts = append(counts, shape.Count)
+			}
+			return counts
+		},
+		"valueCounts": func(values []ValueCount) []int {
+			var counts []int
+			for _, value := range values {
+				counts = append(counts, value.Count)
+			}
+			return counts
+		},
+	}).Parse(ReportTemplate)
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(writer, r)
+}
+
+var ReportTemplate string = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Profile: {{.File}}</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; color: #222; }
+  section { border: 1px solid #ddd; border-radius: 6px; padding: 1em; margin-bottom: 1.5em; }
+  table { border-collapse: collapse; margin: 0.5em 0 1em; }
+  td, th { padding: 2px 8px; text-align: left; vertical-align: top; }
+  th { color: #666; font-weight: normal; }
+  .bar { background: #4a7dbf; height: 1em; }
+  .chart td:nth-child(2) { width: 20em; }
+  code { white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>Profile: {{.File}}</h1>
+<p>{{.Rows}} rows, value and char analysis on a sample of {{.SampleRows}} rows</p>
+{{- with .Hygiene}}
+<section>
+<h2>File Hygiene</h2>
+<table>
+  <tr><th>BOM</th><td>{{.BOM}}</td></tr>
+  <tr><th>Line Endings</th><td>{{range $ending, $n := .LineEndings}}{{$ending}} {{$n}} {{end}}</td></tr>
+  {{- with .FieldCounts}}
+  <tr><th>Fields per Row</th><td>{{range $fields, $n := .}}{{$fields}} -> {{$n}} rows<br>{{end}}</td></tr>
+  {{- end}}
+</table>
+{{- if .Findings}}
+<h3>Findings</h3>
+<table>
+  <tr><th>Issue</th><th>Line</th><th>Byte</th><th>Detail</th></tr>
+  {{- range .Findings}}
+  <tr><td>{{.Issue}}</td><td>{{.Line}}</td><td>{{.Offset}}</td><td>{{.Detail}}</td></tr>
+  {{- end}}
+</table>
+{{- else}}
+<p>No issues found</p>
+{{- end}}
+</section>
+{{- end}}
+{{range .Columns}}
+<section>
+<h2>{{.Name}} <small>{{.Type}}</small></h2>
+<table>
+  <tr><th>Count</th><td>{{.Count}}</td></tr>
+  <tr><th>Null Count</th><td>{{.Nulls}}</td></tr>
+  <tr><th>Empty Count</th><td>{{.Empty}}</td></tr>
+  <tr><th>Whitespace Only Count</th><td>{{.Whitespace}}</td></tr>
+  <tr><th>Approx Unique Count</th><td>{{.ApproxDistinct}}</td></tr>
+  <tr><th>Min</th><td><code>{{.Min}}</code></td></tr>
+  <tr><th>Max</th><td><code>{{.Max}}</code></td></tr>
+  {{- with .Numeric}}
+  <tr><th>Mean</th><td>{{printf "%f" .Mean}}</td></tr>
+  <tr><th>Std Dev</th><td>{{printf "%f" .StdDev}}</td></tr>
+  <tr><th>Percentiles (5%, 25%, 50%, 75%, 95%)</th><td>{{range $i, $p := .Percentiles}}{{if $i}}, {{end}}{{$p}}{{end}}</td></tr>
+  <tr><th>Zeros</th><td>{{.Zeros}}</td></tr>
+  <tr><th>Negatives</th><td>{{.Negatives}}</td></tr>
+  {{- end}}
+  {{- with .Temporal}}
+  <tr><th>Missing Days</th><td>{{.MissingDays}}</td></tr>
+  <tr><th>Day of Week (Sun-Sat)</th><td>{{range $i, $d := .DayOfWeek}}{{if $i}}, {{end}}{{$d}}{{end}}</td></tr>
+  {{- end}}
+  {{- with .Boolean}}
+  <tr><th>True</th><td>{{.True}}</td></tr>
+  <tr><th>False</th><td>{{.False}}</td></tr>
+  {{- end}}
+  {{- with .Lengths}}
+  <tr><th>String Lengths (min, mean, max)</th><td>{{.Min}}, {{printf "%f" .Mean}}, {{.Max}}</td></tr>
+  {{- end}}
+</table>
+{{- if .Histogram}}
+<h3>Histogram</h3>
+<table class="chart">
+  {{- $counts := binCounts .Histogram}}
+  {{- range .Histogram}}
+  <tr><th>{{.Lower}} – {{.Upper}}</th><td><div class="bar" style="width: {{pct .Count $counts}}%"></div></td><td>{{.Count}}</td></tr>
+  {{- end}}
+</table>
+{{- end}}
+<h3>Top Values (sample)</h3>
+<table class="chart">
+  {{- $counts := valueCounts .TopValues}}
+  {{- range .TopValues}}
+  <tr><th><code>{{.Value}}</code></th><td><div class="bar" style="width: {{pct .Count $counts}}%"></div></td><td>{{.Count}}</td></tr>
+  {{- end}}
+</table>
+{{- if .Shapes}}
+<h3>Shapes (sample)</h3>
+<table class="chart">
+  {{- $counts := shapeCounts .Shapes}}
+  {{- range .Shapes}}
+  <tr><th><code>{{.Shape}}</code></th><td><div class="bar" style="width: {{pct .Count $counts}}%"></div></td><td>{{.Count}}</td><td><code>{{range $i, $e := .Examples}}{{if $i}}, {{end}}{{$e}}{{end}}</code></td></tr>
+  {{- end}}
+</table>
+{{- end}}
+{{- if .SemanticTypes}}
+<h3>Semantic Types (sample){{with .SemanticType}}: {{.}}{{end}}</h3>
+<table>
+  {{- range .SemanticTypes}}
+  <tr><th>{{.Type}}</th><td>{{.Count}}</td></tr>
+  {{- end}}
+</table>
+{{- end}}
+<h3>Char Analysis (sample)</h3>
+<table>
+  <tr><th>Control</th><td>{{.Chars.Control}}</td></tr>
+  <tr><th>Comma</th><td>{{.Chars.Comma}}</td></tr>
+  <tr><th>Pipe</th><td>{{.Chars.Pipe}}</td></tr>
+  <tr><th>Quotes</th><td>{{.Chars.Quotes}}</td></tr>
+  <tr><th>Nonspace-Whitespace</th><td>{{.Chars.NonSpaceWhitespace}}</td></tr>
+  <tr><th>NonAscii</th><td>{{.Chars.NonASCII}}</td></tr>
+  <tr><th>Rest</th><td>{{.Chars.Rest}}</td></tr>
+</table>
+</section>
+{{end}}
+</body>
+</html>
+`
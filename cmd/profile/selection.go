@@ -0,0 +1,161 @@
+package profile
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"dct/cmd/utils"
+)
+
+// Selection narrows a profile to some columns and rows, it is pushed down into
+// every query so duckdb only reads what is profiled
+type Selection struct {
+	Columns []string `json:"columns,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	Sample  string   `json:"sample,omitempty"`
+	Where   string   `json:"where,omitempty"`
+}
+
+type InvalidSelectionErr struct {
+	Msg string
+}
+
+func (e InvalidSelectionErr) Error() string {
+	return e.Msg
+}
+
+func (s Selection) IsEmpty() bool {
+	return len(s.Columns) == 0 && len(s.Exclude) == 0 && s.Sample == "" && s.Where == ""
+}
+
+// sampleClause reads a number of rows or a percentage e.g. 1000 or 10%, both
+// are seeded so profiles of the same file are repeatable
+func (s Selection) sampleClause() (string, error) {
+	if pct, ok := strings.CutSuffix(s.Sample, "%"); ok {
+		p, err := strconv.ParseFloat(pct, 64)
+		if err != nil || p <= 0 || p > 100 {
+			return "", InvalidSelectionErr{fmt.Sprintf("expected --sample percentage between 0 and 100: %s", s.Sample)}
+		}
+		return fmt.Sprintf(" using sample %g%% (bernoulli, %d)", p, SAMPLE_SEED), nil
+	}
+
+	rows, err := strconv.Atoi(s.Sample)
+	if err != nil || rows < 1 {
+		return "", InvalidSelectionErr{fmt.Sprintf("expected --sample to be a number of rows or a percentage e.g. 1000 or 10%%: %s", s.Sample)}
+	}
+	return fmt.Sprintf(" using sample reservoir(%d rows) repeatable (%d)", rows, SAMPLE_SEED), nil
+}
+
+// Skips is true for columns left out of the selection
+func (s Selection) Skips(col string) bool {
+	return slices.Contains(s.Exclude, col) || (len(s.Columns) > 0 && !slices.Contains(s.Columns, col))
+}
+
+// columns lists the selected columns of the file in the order asked for, or
+// in file order when only excluding
+func (s Selection) columns(headers []utils.Header) ([]string, error) {
+	var names []string
+	for _, header := range headers {
+		names = append(names, header.Name)
+	}
+
+	for _, col := range slices.Concat(s.Columns, s.Exclude) {
+		if !slices.Contains(names, col) {
+			return nil, InvalidSelectionErr{fmt.Sprintf("unknown column `%s`, expected one of %v", col, names)}
+		}
+	}
+
+	if len(s.Columns) > 0 {
+		names = s.Columns
+	}
+
+	var selected []string
+	for _, name := range names {
+		if !s.Skips(name) {
+			selected = append(selected, name)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, InvalidSelectionErr{"expected at least one column to profile"}
+	}
+	return selected, nil
+}
+
+// Relation is the file as duckdb reads it for the profile, a subquery when
+// columns or rows are selected. A sample is drawn once into a temporary file
+// so every section of the report profiles the same rows, the returned func
+// removes it
+func (s Selection) Relation(file string) (string, func(), error) {
+	source := fmt.Sprintf("'%s'", file)
+	if s.IsEmpty() {
+		return source, func() {}, nil
+	}
+
+	projection := "*"
+	if len(s.Columns) > 0 || len(s.Exclude) > 0 {
+		headers, err := describe(source)
+		if err != nil {
+			return "", nil, err
+		}
+		cols, err := s.columns(headers)
+		if err != nil {
+			return "", nil, err
+		}
+		projection = strings.Join(quoteAll(cols), ", ")
+	}
+
+	relation := fmt.Sprintf("select %s from %s", projection, source)
+	if s.Where != "" {
+		relation += fmt.Sprintf(" where %s", s.Where)
+	}
+
+	if s.Sample == "" {
+		return fmt.Sprintf("(%s)", relation), func() {}, nil
+	}
+
+	// samples are taken after the where clause
+	clause, err := s.sampleClause()
+	if err != nil {
+		return "", nil, err
+	}
+	return materialise(fmt.Sprintf("select * from (%s)%s", relation, clause))
+}
+
+// materialise writes the rows of a query to a temporary parquet file, each
+// query runs on its own connection so a temp table wouldn't outlive it
+func materialise(query string) (string, func(), error) {
+	tmp, err := os.CreateTemp("", "dct-prof-*.parquet")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create sample file: %v", err)
+	}
+	_ = tmp.Close()
+	cleanup := func() { _ = os.Remove(tmp.Name()) }
+
+	relation := utils.QuoteLiteral(tmp.Name())
+	err = utils.Execute(fmt.Sprintf("copy (%s) to %s (format parquet)", query, relation))
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to sample rows: %v", err)
+	}
+	return relation, cleanup, nil
+}
+
+func (s Selection) String() string {
+	var parts []string
+	if len(s.Columns) > 0 {
+		parts = append(parts, fmt.Sprintf("columns %s", strings.Join(s.Columns, ", ")))
+	}
+	if len(s.Exclude) > 0 {
+		parts = append(parts, fmt.Sprintf("excluding %s", strings.Join(s.Exclude, ", ")))
+	}
+	if s.Where != "" {
+		parts = append(parts, fmt.Sprintf("where %s", s.Where))
+	}
+	if s.Sample != "" {
+		parts = append(parts, fmt.Sprintf("sample of %s", s.Sample))
+	}
+	return strings.Join(parts, ", ")
+}
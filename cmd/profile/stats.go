@@ -0,0 +1,286 @@
+package profile
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"dct/cmd/utils"
+)
+
+const (
+	// rows read into memory for value and char analysis
+	SAMPLE_ROWS = 10_000
+	SAMPLE_SEED = 42
+)
+
+var (
+	PERCENTILES = []float64{0.05, 0.25, 0.5, 0.75, 0.95}
+	// ordered to match duckdb's dayofweek, sunday is 0
+	WEEKDAYS = []time.Weekday{
+		time.Sunday,
+		time.Monday,
+		time.Tuesday,
+		time.Wednesday,
+		time.Thursday,
+		time.Friday,
+		time.Saturday,
+	}
+)
+
+type ColumnProfile struct {
+	Name           string         `json:"name"`
+	Type           string         `json:"type"`
+	Count          int            `json:"count"`
+	Nulls          int            `json:"nulls"`
+	Empty          int            `json:"empty"`
+	Whitespace     int            `json:"whitespace"`
+	ApproxDistinct int            `json:"approx_distinct"`
+	Min            string         `json:"min"`
+	Max            string         `json:"max"`
+	Numeric        *NumericStats  `json:"numeric,omitempty"`
+	Temporal       *TemporalStats `json:"temporal,omitempty"`
+	Boolean        *BooleanStats  `json:"boolean,omitempty"`
+	Lengths        *Summary       `json:"lengths,omitempty"`
+}
+
+type NumericStats struct {
+	Mean        float64   `json:"mean"`
+	StdDev      float64   `json:"std_dev"`
+	Percentiles []float64 `json:"percentiles"`
+	Zeros       int       `json:"zeros"`
+	Negatives   int       `json:"negatives"`
+}
+
+type TemporalStats struct {
+	MissingDays int   `json:"missing_days"`
+	DayOfWeek   []int `json:"day_of_week"`
+}
+
+type BooleanStats struct {
+	True  int `json:"true"`
+	False int `json:"false"`
+}
+
+func isNumeric(t string) bool {
+	switch {
+	case strings.HasPrefix(t, "DECIMAL"):
+		return true
+	case strings.HasSuffix(t, "INT"), t == "INTEGER", t == "FLOAT", t == "DOUBLE":
+		return true
+	default:
+		return false
+	}
+}
+
+func isTemporal(t string) bool {
+	return t == "DATE" || strings.HasPrefix(t, "TIMESTAMP")
+}
+
+func isBoolean(t string) bool {
+	return t == "BOOLEAN"
+}
+
+func isText(t string) bool {
+	return !isNumeric(t) && !isTemporal(t) && !isBoolean(t) && t != "TIME"
+}
+
+type aggregates []string
+
+func (a *aggregates) add(col int, stat string, expr string, args ...any) {
+	*a = append(*a, fmt.Sprintf("%s as c%d_%s", fmt.Sprintf(expr, args...), col, stat))
+}
+
+// generateStatsSQL computes the stats of every column in a single scan so
+// files larger than memory can be profiled, the stats depend on column type
+func generateStatsSQL(relation string, headers []utils.Header) string {
+	var aggs aggregates
+	for i, header := range headers {
+		col := utils.QuoteIdent(header.Name)
+		text := col + "::varchar"
+
+		aggs.add(i, "count", "count(*)")
+		aggs.add(i, "nulls", "count(*) - count(%s)", col)
+		aggs.add(i, "distinct", "approx_count_distinct(%s)", text)
+		aggs.add(i, "min", "min(%s)::varchar", col)
+		aggs.add(i, "max", "max(%s)::varchar", col)
+
+		switch {
+		case isNumeric(header.Type):
+			var percentiles []string
+			for _, p := range PERCENTILES {
+				percentiles = append(percentiles, fmt.Sprint(p))
+			}
+			aggs.add(i, "mean", "avg(%s)::double", col)
+			aggs.add(i, "stddev", "stddev_samp(%s)::double", col)
+			aggs.add(i, "percentiles", "approx_quantile(%s, [%s])::double[]", col, strings.Join(percentiles, ", "))
+			aggs.add(i, "zeros", "count_if(%s = 0)::bigint", col)
+			aggs.add(i, "negatives", "count_if(%s < 0)::bigint", col)
+		case isTemporal(header.Type):
+			aggs.add(
+				i,
+				"missing_days",
+				"date_diff('day', min(%[1]s)::date, max(%[1]s)::date) + 1 - count(distinct %[1]s::date)",
+				col,
+			)
+			for d := range WEEKDAYS {
+				aggs.add(i, fmt.Sprintf("dow_%d", d), "count_if(dayofweek(%s) = %d)::bigint", col, d)
+			}
+		case isBoolean(header.Type):
+			aggs.add(i, "true", "count_if(%s)::bigint", col)
+			aggs.add(i, "false", "count_if(not %s)::bigint", col)
+		case isText(header.Type):
+			aggs.add(i, "empty", "count_if(%s = '')::bigint", text)
+			aggs.add(i, "whitespace", "count_if(regexp_full_match(%s, '\\s+'))::bigint", text)
+			aggs.add(i, "min_length", "min(length(%s))", text)
+			aggs.add(i, "mean_length", "avg(length(%s))", text)
+			aggs.add(i, "max_length", "max(length(%s))", text)
+		}
+	}
+
+	return fmt.Sprintf("select %s from %s", strings.Join(aggs, ",\n  "), relation)
+}
+
+func describe(relation string) ([]utils.Header, error) {
+	result, err := utils.Query(fmt.Sprintf("select * from %s limit 0", relation))
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Headers, nil
+}
+
+func summariseColumns(relation string, headers []utils.Header) ([]ColumnProfile, error) {
+	result, err := utils.Query(generateStatsSQL(relation, headers))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]any)
+	for i, header := range result.Headers {
+		values[header.Name] = result.Rows[0][i]
+	}
+
+	var profiles []ColumnProfile
+	for i, header := range headers {
+		stat := func(name string) any {
+			return values[fmt.Sprintf("c%d_%s", i, name)]
+		}
+		asInt := func(name string) int {
+			v, _ := stat(name).(int)
+			return v
+		}
+		asFloat := func(name string) float64 {
+			v, _ := stat(name).(float64)
+			return v
+		}
+
+		p := ColumnProfile{Name: header.Name, Type: header.Type}
+		p.Count = asInt("count")
+		p.Nulls = asInt("nulls")
+		p.ApproxDistinct = asInt("distinct")
+		p.Min, _ = stat("min").(string)
+		p.Max, _ = stat("max").(string)
+
+		switch {
+		case isNumeric(header.Type):
+			p.Numeric = &NumericStats{
+				Mean:      asFloat("mean"),
+				StdDev:    asFloat("stddev"),
+				Zeros:     asInt("zeros"),
+				Negatives: asInt("negatives"),
+			}
+			percentiles, _ := stat("percentiles").([]any)
+			for _, v := range percentiles {
+				f, _ := v.(float64)
+				p.Numeric.Percentiles = append(p.Numeric.Percentiles, f)
+			}
+		case isTemporal(header.Type):
+			p.Temporal = &TemporalStats{MissingDays: asInt("missing_days")}
+			for d := range WEEKDAYS {
+				p.Temporal.DayOfWeek = append(p.Temporal.DayOfWeek, asInt(fmt.Sprintf("dow_%d", d)))
+			}
+		case isBoolean(header.Type):
+			p.Boolean = &BooleanStats{True: asInt("true"), False: asInt("false")}
+		case isText(header.Type):
+			p.Empty = asInt("empty")
+			p.Whitespace = asInt("whitespace")
+			p.Lengths = &Summary{
+				Min:   asInt("min_length"),
+				Mean:  asFloat("mean_length"),
+				Max:   asInt("max_length"),
+				Count: p.Count - p.Nulls,
+			}
+		}
+
+		profiles = append(profiles, p)
+	}
+
+	return profiles, nil
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func (p ColumnProfile) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Type: %s\n", p.Type)
+	fmt.Fprintf(&s, "Count: %d\n", p.Count)
+	fmt.Fprintf(&s, "Null Count: %d\n", p.Nulls)
+	fmt.Fprintf(&s, "Empty Count: %d\n", p.Empty)
+	fmt.Fprintf(&s, "Whitespace Only Count: %d\n", p.Whitespace)
+	fmt.Fprintf(&s, "Approx Unique Count: %d\n", p.ApproxDistinct)
+	fmt.Fprintf(&s, "Min: %s\n", p.Min)
+	fmt.Fprintf(&s, "Max: %s\n", p.Max)
+
+	return s.String()
+}
+
+func (n NumericStats) String() string {
+	var percentiles, values []string
+	for i, p := range PERCENTILES {
+		percentiles = append(percentiles, fmt.Sprintf("%g%%", p*100))
+		if i < len(n.Percentiles) {
+			values = append(values, fmt.Sprintf("%g", n.Percentiles[i]))
+		}
+	}
+
+	return fmt.Sprintf(`Mean: %f
+Std Dev: %f
+Percentiles (%s): %s
+Zeros: %d
+Negatives: %d`,
+		n.Mean,
+		n.StdDev,
+		strings.Join(percentiles, ", "),
+		strings.Join(values, ", "),
+		n.Zeros,
+		n.Negatives,
+	)
+}
+
+func (t TemporalStats) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Missing Days: %d\n", t.MissingDays)
+	s.WriteString("Day of Week")
+	for i, count := range t.DayOfWeek {
+		fmt.Fprintf(&s, "\n%s: %d", WEEKDAYS[i].String()[:3], count)
+	}
+
+	return s.String()
+}
+
+func (b BooleanStats) String(nulls int) string {
+	total := b.True + b.False + nulls
+	return fmt.Sprintf(`True: %d (%.2f%%)
+False: %d (%.2f%%)
+Null: %d (%.2f%%)`,
+		b.True, ratio(b.True, total),
+		b.False, ratio(b.False, total),
+		nulls, ratio(nulls, total),
+	)
+}
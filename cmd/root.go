@@ -5,6 +5,7 @@ import (
 
 	"dct/cmd/art"
 	"dct/cmd/chart"
+	"dct/cmd/check"
 	"dct/cmd/diff"
 	"dct/cmd/flattify"
 	"dct/cmd/generator"
@@ -34,6 +35,7 @@ func init() {
 	rootCmd.AddCommand(flattify.FlattifyCmd)
 	rootCmd.AddCommand(profile.ProfileCmd)
 	rootCmd.AddCommand(js2sql.Js2SqlCmd)
+	rootCmd.AddCommand(check.CheckCmd)
 }
 
 func Execute() {
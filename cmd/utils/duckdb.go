@@ -11,6 +11,7 @@ import (
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/lipgloss/table"
+	"github.com/google/uuid"
 
 	_ "github.com/marcboeker/go-duckdb"
 )
@@ -32,19 +33,32 @@ type Result struct {
 	Rows    [][]any
 }
 
-func CheckFileHasRows(file string) (bool, error) {
+// QuoteIdent quotes a column name for use in a query
+func QuoteIdent(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(name, `"`, `""`))
+}
+
+// QuoteLiteral quotes a string such as a file path for use in a query
+func QuoteLiteral(value string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(value, "'", "''"))
+}
+
+func CheckSourceHasRows(source Source) (bool, error) {
 	conn, err := sql.Open("duckdb", "")
 	if err != nil {
 		return false, err
 	}
+	defer func() { _ = conn.Close() }()
 
 	row := conn.QueryRowContext(
 		context.Background(),
-		fmt.Sprintf("select count(*) from '%s'", file),
+		fmt.Sprintf("%sselect count(*) from %s", source.Setup(), source.Relation()),
 	)
 
 	var cnt int
-	_ = row.Scan(&cnt)
+	if err := row.Scan(&cnt); err != nil {
+		return false, err
+	}
 	return cnt > 0, nil
 }
 
@@ -100,7 +114,7 @@ func Query(query string) (Result, error) {
 		}
 
 		var tmp []any
-		for _, v := range vals {
+		for i, v := range vals {
 			deref := reflect.Indirect(reflect.ValueOf(v)).Interface()
 			switch deref := deref.(type) {
 			case nil:
@@ -125,6 +139,12 @@ func Query(query string) (Result, error) {
 				tmp = append(tmp, deref)
 			case map[string]any:
 				tmp = append(tmp, deref)
+			case []byte:
+				// uuids are scanned as their 16 bytes
+				if headers[i].Type != "UUID" || len(deref) != 16 {
+					return Result{}, fmt.Errorf("failed to serialise rows from duckdb, type `%s` not implemented yet", headers[i].Type)
+				}
+				tmp = append(tmp, uuid.UUID(deref).String())
 			default:
 				return Result{}, fmt.Errorf(
 					"failed to serialise rows from duckdb, type `%T` not implemented yet",
@@ -180,6 +200,13 @@ func (result *Result) RowsToString() [][]string {
 }
 
 func (result *Result) Render(writer io.Writer, maxRows int) error {
+	return result.RenderStyled(writer, maxRows, nil)
+}
+
+// CellStyler adjusts the style of a data cell, row and col index into Result.Rows
+type CellStyler func(row, col int, base lipgloss.Style) lipgloss.Style
+
+func (result *Result) RenderStyled(writer io.Writer, maxRows int, styler CellStyler) error {
 	// TODO: handle wide tables!
 	var headers []string
 	var types []string
@@ -191,14 +218,19 @@ func (result *Result) Render(writer io.Writer, maxRows int) error {
 	t := table.New().
 		Border(lipgloss.RoundedBorder()).
 		StyleFunc(func(row, col int) lipgloss.Style {
-			switch row {
-			case 3:
+			base := style
+			if row == 3 {
 				// force border after type row in display
-				return style.
+				base = style.
 					Border(lipgloss.NormalBorder(), true, false, false, false)
-			default:
-				return style
 			}
+
+			// first two rows are the headers and types
+			if styler != nil && row >= 3 {
+				return styler(row-3, col, base)
+			}
+
+			return base
 		}).
 		Rows(headers).
 		Rows(types)
@@ -207,8 +239,8 @@ func (result *Result) Render(writer io.Writer, maxRows int) error {
 
 	t.Rows(result.RowsToString()[:rowsToDisplay]...)
 
-	fmt.Println(t)
-	return nil
+	_, err := fmt.Fprintln(writer, t)
+	return err
 }
 
 func (result *Result) ToSQL(table string) string {
@@ -7,6 +7,8 @@ const (
 	JSON         string = ".json"
 	NDJSON       string = ".ndjson"
 	PARQUET      string = ".parquet"
+	YAML         string = ".yaml"
+	YML          string = ".yml"
 	INVALID_FILE string = "invalid"
 )
 
@@ -15,6 +17,8 @@ var (
 	INFER_SUPPORTED_FILETYPES    = []string{CSV, JSON, NDJSON, PARQUET}
 	PROFILE_SUPPORTED_FILETYPES  = []string{CSV, JSON, NDJSON, PARQUET}
 	FLATTIFY_SUPPORTED_FILETYPES = []string{JSON, NDJSON}
+	CHECK_RULES_FILETYPES        = []string{YAML, YML, JSON}
+	GENERATOR_OUTPUT_FILETYPES   = []string{CSV, NDJSON, JSON, PARQUET}
 )
 
 type UnsupportedFileTypeErr struct {
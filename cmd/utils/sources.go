@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	FILE_SOURCE   string = "file"
+	SQLITE_SOURCE string = "sqlite"
+	DUCKDB_SOURCE string = "duckdb"
+)
+
+// Source is something dct can select from, either a data file or a table or
+// query in a database given as `sqlite:///path.db?table=orders` or
+// `duckdb:///path.duckdb?query=select ...`. Like SQLAlchemy urls, the path
+// after `:///` is relative unless it starts with another `/`
+type Source struct {
+	Kind     string
+	Path     string
+	Table    string
+	Query    string
+	Name     string
+	Database string
+}
+
+type InvalidSourceErr struct {
+	Msg    string
+	Source string
+}
+
+func (e InvalidSourceErr) Error() string {
+	return fmt.Sprintf("%s: %s", e.Msg, e.Source)
+}
+
+// ParseSource reads a file path or database url, name keeps the attached
+// database and query results apart when several sources are used in one query
+func ParseSource(raw string, name string) (Source, error) {
+	for _, kind := range []string{SQLITE_SOURCE, DUCKDB_SOURCE} {
+		rest, ok := strings.CutPrefix(raw, kind+"://")
+		if !ok {
+			continue
+		}
+
+		path, params, _ := strings.Cut(rest, "?")
+		path = strings.TrimPrefix(path, "/")
+		if path == "" {
+			return Source{}, InvalidSourceErr{"missing database path", raw}
+		}
+
+		source := Source{Kind: kind, Path: path, Name: name, Database: name + "_db"}
+		param, value, _ := strings.Cut(params, "=")
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return Source{}, InvalidSourceErr{fmt.Sprintf("failed to unescape %s", param), raw}
+		}
+
+		switch {
+		case param == "table" && value != "":
+			source.Table = value
+		case param == "query" && value != "":
+			source.Query = value
+		default:
+			return Source{}, InvalidSourceErr{"expected one of ?table= or ?query=", raw}
+		}
+
+		return source, nil
+	}
+
+	return Source{Kind: FILE_SOURCE, Path: raw, Name: name}, nil
+}
+
+// Setup returns the statements to run before selecting from the source
+func (s Source) Setup() string {
+	if s.Kind == FILE_SOURCE {
+		return ""
+	}
+
+	var sql string
+	options := "read_only"
+	if s.Kind == SQLITE_SOURCE {
+		sql = "install sqlite;\nload sqlite;\n"
+		options = "type sqlite, read_only"
+	}
+
+	sql += fmt.Sprintf("attach if not exists %s as %s (%s);\n", QuoteLiteral(s.Path), QuoteIdent(s.Database), options)
+	if s.Query != "" {
+		// the query is written against the attached database
+		sql += fmt.Sprintf(
+			"use %s;\ncreate or replace temp table %s as %s;\nuse memory;\n",
+			QuoteIdent(s.Database),
+			QuoteIdent(s.Name+"_query"),
+			strings.TrimSuffix(strings.TrimSpace(s.Query), ";"),
+		)
+	}
+
+	return sql
+}
+
+// Relation returns the source as used in a from clause, a table may be
+// qualified by its schema e.g. ?table=sales.orders
+func (s Source) Relation() string {
+	switch {
+	case s.Kind == FILE_SOURCE:
+		return QuoteLiteral(s.Path)
+	case s.Query != "":
+		return fmt.Sprintf("temp.%s", QuoteIdent(s.Name+"_query"))
+	default:
+		var parts []string
+		for _, part := range strings.Split(s.Table, ".") {
+			parts = append(parts, QuoteIdent(part))
+		}
+		return fmt.Sprintf("%s.%s", QuoteIdent(s.Database), strings.Join(parts, "."))
+	}
+}
+
+func (s Source) String() string {
+	if s.Kind == FILE_SOURCE {
+		return s.Path
+	}
+	return fmt.Sprintf("%s://%s", s.Kind, s.Path)
+}
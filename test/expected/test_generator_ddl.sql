@@ -0,0 +1,17 @@
+create table people (
+    "uuid" varchar not null,
+    "random" varchar not null,
+    "age" bigint not null,
+    "height" double not null,
+    "weight" double not null,
+    "siblings" bigint not null,
+    "first_name" varchar not null,
+    "last_name" varchar not null,
+    "full_name" varchar,
+    "bmi" double,
+    "rainbow" double,
+    "last_notification_at" timestamptz not null,
+    "date_of_birth" date not null,
+    "wake_up" time not null,
+    "title" varchar not null
+)
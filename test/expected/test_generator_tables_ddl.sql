@@ -0,0 +1,18 @@
+create table customers (
+    "id" varchar not null unique,
+    "first_name" varchar not null,
+    "last_name" varchar not null,
+    "email" varchar not null
+);
+
+create table orders (
+    "id" varchar not null unique,
+    "customer_id" varchar not null references customers("id"),
+    "ordered_at" timestamptz not null
+);
+
+create table order_items (
+    "order_id" varchar not null references orders("id"),
+    "product" varchar not null,
+    "quantity" bigint not null
+);
@@ -2,6 +2,14 @@
 import subprocess
 import pytest
 import os
+import json
+import re
+import datetime
+import csv
+import glob
+import io
+import shutil
+import sqlite3
 
 PEEK_SUPPORTED_FILE_TYPES = ["csv", "json", "ndjson", "parquet"]
 PROFILE_SUPPORTED_FILE_TYPES = ["csv", "json", "ndjson", "parquet"]
@@ -105,6 +113,24 @@ def test_diff_empty():
     )
 
 
+def test_diff_invalid_source():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "sqlite:///test/resources/left.db",
+            "./test/resources/right.csv",
+        ],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "expected one of ?table= or ?query=: sqlite:///test/resources/left.db\n"
+    )
+
+
 def test_diff_equal():
     out = subprocess.run(
         [
@@ -150,6 +176,61 @@ def test_diff_keys():
     assert out.stdout == open("./test/expected/test_diff_keys.txt", mode="rb").read()
 
 
+# dct diff "customer_id::bigint=cust_id,lower(email)=email" test/resources/customers_left.csv test/resources/customers_right.csv -r --no-color
+def test_diff_key_expressions():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "customer_id::bigint=cust_id,lower(email)=email",
+            "./test/resources/customers_left.csv",
+            "./test/resources/customers_right.csv",
+            "-r",
+            "--no-color",
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        out.stdout
+        == open("./test/expected/test_diff_key_expressions.txt", mode="rb").read()
+    )
+
+
+def test_diff_key_type_mismatch():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "customer_id=cust_id",
+            "./test/resources/customers_left.csv",
+            "./test/resources/customers_right.csv",
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        b"key `customer_id` is VARCHAR on the left and BIGINT on the right"
+        in out.stderr
+    )
+
+
+def test_diff_key_invalid():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "custid=cust_id",
+            "./test/resources/customers_left.csv",
+            "./test/resources/customers_right.csv",
+        ],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert b"invalid keys for left ./test/resources/customers_left.csv" in out.stderr
+
+
 # dct diff a test/resources/left.csv test/resources/right.csv -m '[{"agg":"mean","left":"b","right":"b"},{"agg":"count_distinct","left":"c","right":"c"}]'
 def test_diff_metric_string():
     out = subprocess.run(
@@ -238,6 +319,275 @@ def test_diff_output():
     os.remove("./tmp_test_diff_output.csv")
 
 
+def test_diff_output_views():
+    subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "./test/resources/left.csv",
+            "./test/resources/right.csv",
+            "-g",
+            "b",
+            "-r",
+            "-o",
+            "./tmp_test_diff_output_views.csv",
+        ],
+    )
+
+    # the summary is written to --output and every other view next to it
+    for view in ["", "-groups", "-rows"]:
+        written = f"./tmp_test_diff_output_views{view}.csv"
+        assert (
+            open(written, mode="rb").read()
+            == open(f"./test/expected/test_diff_output_views{view}.csv", mode="rb").read()
+        )
+        os.remove(written)
+
+
+# dct diff a test/resources/left.csv test/resources/right.csv -g b,c
+def test_diff_group_by():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "./test/resources/left.csv",
+            "./test/resources/right.csv",
+            "-g",
+            "b,c",
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        out.stdout == open("./test/expected/test_diff_group_by.txt", mode="rb").read()
+    )
+
+
+# dct diff a test/resources/left.csv test/resources/right.csv --sample-keys 100%
+def test_diff_sample_keys():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "./test/resources/left.csv",
+            "./test/resources/right.csv",
+            "--sample-keys",
+            "100%",
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        out.stdout
+        == open("./test/expected/test_diff_sample_keys.txt", mode="rb").read()
+    )
+
+
+def test_diff_sample_keys_output():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "./test/resources/left.csv",
+            "./test/resources/right.csv",
+            "--sample-keys",
+            "100%",
+            "-o",
+            "./tmp_test_diff_sample_keys.csv",
+        ],
+        capture_output=True,
+    )
+
+    # the sample summary is written next to --output like the other views
+    assert out.stderr == b""
+    for view in ["", "-sample"]:
+        written = f"./tmp_test_diff_sample_keys{view}.csv"
+        assert (
+            open(written, mode="rb").read()
+            == open(f"./test/expected/test_diff_sample_keys{view}.csv", mode="rb").read()
+        )
+        os.remove(written)
+
+
+def test_diff_sample_keys_invalid():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "./test/resources/left.csv",
+            "./test/resources/right.csv",
+            "--sample-keys",
+            "200%",
+        ],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "sample must be between 0% and 100%: 200%\n"
+    )
+
+
+# dct diff a test/resources/left.csv test/resources/right.csv -r --no-color
+def test_diff_rows():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "./test/resources/left.csv",
+            "./test/resources/right.csv",
+            "-r",
+            "--no-color",
+        ],
+        capture_output=True,
+    )
+
+    assert out.stdout == open("./test/expected/test_diff_rows.txt", mode="rb").read()
+
+
+
+# dct diff a "duckdb:///test/resources/shop.duckdb?table=left" "duckdb:///test/resources/shop.duckdb?table=right"
+def test_diff_duckdb_table():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "duckdb:///test/resources/shop.duckdb?table=left",
+            "duckdb:///test/resources/shop.duckdb?table=right",
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        out.stdout == open("./test/expected/test_diff_duckdb_table.txt", mode="rb").read()
+    )
+
+
+# dct diff a 'duckdb:///test/resources/shop.duckdb?query=select * from "left" where b > 1' test/resources/right.csv -r --no-color
+def test_diff_duckdb_query():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            'duckdb:///test/resources/shop.duckdb?query=select * from "left" where b > 1',
+            "./test/resources/right.csv",
+            "-r",
+            "--no-color",
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        out.stdout == open("./test/expected/test_diff_duckdb_query.txt", mode="rb").read()
+    )
+
+
+def test_diff_duckdb_quoted():
+    # a path with a quote and a table with a space and capitals
+    shutil.copy("./test/resources/shop.duckdb", "./tmp_test_it's shop.duckdb")
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "order_id,line_no",
+            "duckdb:///tmp_test_it's shop.duckdb?table=Order Lines",
+            "./test/resources/order_lines.csv",
+        ],
+        capture_output=True,
+    )
+    injected = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "duckdb:///tmp_test_it's shop.duckdb?table=left; drop table orders",
+            "./test/resources/left.csv",
+        ],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_it's shop.duckdb")
+
+    assert out.returncode == 0
+    assert b"order_id" in out.stdout and b"l_cnt" in out.stdout
+    assert injected.returncode != 0
+    assert b'Table with name left; drop table orders does not exist' in injected.stderr
+
+
+def test_diff_sqlite():
+    db = sqlite3.connect("./tmp_test_diff.db")
+    db.execute('create table "My Orders" (a integer, b integer, c text)')
+    with open("./test/resources/left.csv") as f:
+        db.executemany('insert into "My Orders" values (?, ?, ?)', list(csv.reader(f))[1:])
+    db.commit()
+    db.close()
+
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "a",
+            "sqlite:///tmp_test_diff.db?table=My Orders",
+            "./test/resources/right.csv",
+        ],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_diff.db")
+
+    # the sqlite extension is downloaded on first use
+    if b"Failed to download extension" in out.stderr:
+        pytest.skip("sqlite extension is not installed")
+    assert out.returncode == 0
+    assert out.stdout == open("./test/expected/test_diff_duckdb_table.txt", mode="rb").read()
+
+
+# dct diff '"account id"' test/resources/accounts_left.csv test/resources/accounts_right.csv -r --no-color
+def test_diff_quoted_key():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            '"account id"',
+            "./test/resources/accounts_left.csv",
+            "./test/resources/accounts_right.csv",
+            "-r",
+            "--no-color",
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        out.stdout == open("./test/expected/test_diff_quoted_key.txt", mode="rb").read()
+    )
+
+
+# dct diff region test/resources/accounts_left.csv test/resources/accounts_right.csv -g '"account id"'
+def test_diff_group_by_quoted_key():
+    out = subprocess.run(
+        [
+            "./dct",
+            "diff",
+            "region",
+            "./test/resources/accounts_left.csv",
+            "./test/resources/accounts_right.csv",
+            "-g",
+            '"account id"',
+        ],
+        capture_output=True,
+    )
+
+    assert (
+        out.stdout
+        == open("./test/expected/test_diff_group_by_quoted_key.txt", mode="rb").read()
+    )
+
 def test_chart():
     out = subprocess.run(
         [
@@ -331,6 +681,810 @@ def test_generator_inline():
     assert out.stderr == b""
 
 
+def helper_generator_seed(seed: str, format: str):
+    return subprocess.run(
+        [
+            "./dct",
+            "gen",
+            "test/resources/generator-schema.json",
+            "-n",
+            "10",
+            "-f",
+            format,
+            "--seed",
+            seed,
+        ],
+        capture_output=True,
+    )
+
+
+def test_generator_seed():
+    first = helper_generator_seed("42", "csv")
+    second = helper_generator_seed("42", "csv")
+
+    assert first.stderr == b""
+    assert first.stdout == second.stdout
+    assert (
+        first.stdout
+        == open("./test/expected/test_generator_seed.csv", mode="rb").read()
+    )
+
+
+def test_generator_seed_ndjson():
+    first = helper_generator_seed("42", "ndjson")
+    second = helper_generator_seed("42", "ndjson")
+
+    assert first.stderr == b""
+    assert first.stdout == second.stdout
+    assert (
+        first.stdout
+        == open("./test/expected/test_generator_seed.ndjson", mode="rb").read()
+    )
+
+
+def test_generator_seed_emails():
+    schema = '[{"field": "email", "source": "emails"}, {"field": "id", "source": "uuid"}]'
+    runs = [
+        subprocess.run(
+            ["./dct", "gen", schema, "-n", "20", "--seed", "7"],
+            capture_output=True,
+        ).stdout
+        for _ in range(2)
+    ]
+
+    assert runs[0] == runs[1]
+
+
+def test_generator_seed_differs():
+    assert (
+        helper_generator_seed("1", "csv").stdout
+        != helper_generator_seed("2", "csv").stdout
+    )
+
+
+def test_generator_json():
+    out = subprocess.run(
+        [
+            "./dct",
+            "gen",
+            "test/resources/generator-schema.json",
+            "-n",
+            "5",
+            "-f",
+            "json",
+            "--seed",
+            "42",
+        ],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = json.loads(out.stdout)
+    assert len(rows) == 5
+    assert rows[0] == json.loads(
+        open("./test/expected/test_generator_seed.ndjson", mode="rb").readline()
+    )
+
+
+def test_generator_parquet():
+    subprocess.run(
+        [
+            "./dct",
+            "gen",
+            "test/resources/generator-schema.json",
+            "-n",
+            "100",
+            "-f",
+            "parquet",
+            "-o",
+            "tmp_test_generator.parquet",
+            "--compression",
+            "zstd",
+            "--row-group-size",
+            "10",
+        ],
+    )
+    out = subprocess.run(
+        ["./dct", "infer", "tmp_test_generator.parquet"],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_generator.parquet")
+
+    assert out.stdout == open(
+        "./test/expected/test_generator_parquet.txt", mode="rb"
+    ).read()
+
+
+def test_generator_parquet_stdout():
+    out = subprocess.run(
+        ["./dct", "gen", "test/resources/generator-schema.json", "-f", "parquet"],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: parquet can't be written to stdout, expected --outfile\n"
+    )
+
+
+def test_generator_format_invalid():
+    out = subprocess.run(
+        ["./dct", "gen", "test/resources/generator-schema.json", "-f", "xml"],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert out.stdout == b""
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: unsupported format: xml, expected one of [.csv .ndjson .json .parquet]\n"
+    )
+
+
+def test_generator_ddl():
+    out = subprocess.run(
+        [
+            "./dct",
+            "gen",
+            "test/resources/generator-schema.json",
+            "--ddl",
+            "-t",
+            "people",
+        ],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert out.stdout == open("./test/expected/test_generator_ddl.sql", mode="rb").read()
+
+
+def test_generator_types():
+    schema = """[
+    {"field": "n", "source": "randomPoisson", "config": {"lambda": 2}},
+    {"field": "day", "source": "randomDate", "config": {"min": "2024-01-01", "max": "2024-02-01"}},
+    {"field": "big", "source": "derived", "config": {"fields": ["n"], "expression": "n > 2 ? n : nil"}},
+    {"field": "label", "source": "derived", "config": {"fields": ["n"], "expression": "n", "type": "string"}}
+]"""
+    out = subprocess.run(
+        ["./dct", "gen", schema, "-n", "50", "-f", "ndjson", "--seed", "1"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = [json.loads(line) for line in out.stdout.splitlines()]
+    assert all(type(r["n"]) is int for r in rows)
+    assert all(r["day"].startswith("2024-01") for r in rows)
+    assert all(r["big"] is None or r["big"] > 2 for r in rows)
+    assert any(r["big"] is None for r in rows)
+    assert all(r["label"] == str(r["n"]) for r in rows)
+
+
+FAULTS_SCHEMA = """[
+    {"field": "id", "source": "uuid", "duplicateRate": 0.2},
+    {"field": "age", "source": "randomUniformInt", "config": {"min": 0, "max": 100}, "nullRate": 0.2, "corruptRate": 0.3, "corruptions": ["outOfRange"]},
+    {"field": "name", "source": "firstNames", "emptyRate": 0.1, "corruptRate": 0.4, "corruptions": ["whitespace", "confusables", "wrongType"]},
+    {"field": "born", "source": "randomDate", "config": {"min": "2000-01-01", "max": "2001-01-01"}, "corruptRate": 0.3, "corruptions": ["malformedDate"]},
+    {"field": "greeting", "source": "derived", "config": {"fields": ["name"], "expression": "'hi ' + name"}}
+]"""
+
+
+def test_generator_faults():
+    out = subprocess.run(
+        ["./dct", "gen", FAULTS_SCHEMA, "-n", "12", "--seed", "3"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert out.stdout == open("./test/expected/test_generator_faults.csv", mode="rb").read()
+
+
+def test_generator_faults_rates():
+    out = subprocess.run(
+        ["./dct", "gen", FAULTS_SCHEMA, "-n", "2000", "-f", "ndjson", "--seed", "5"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = [json.loads(line) for line in out.stdout.splitlines()]
+    ids = [r["id"] for r in rows]
+    ages = [r["age"] for r in rows]
+    assert 0.15 * len(ids) < len(ids) - len(set(ids)) < 0.25 * len(ids)
+    assert 0.15 < ages.count(None) / len(ages) < 0.25
+    assert any(age is not None and age < 0 for age in ages)
+    assert all(r["greeting"].startswith("hi ") and r["greeting"] == r["greeting"].strip() for r in rows)
+    assert any(r["name"] == "" for r in rows)
+    assert any(r["name"] != r["name"].strip() for r in rows)
+    assert any(not r["name"].isascii() for r in rows)
+
+
+def test_generator_faults_ddl():
+    out = subprocess.run(
+        ["./dct", "gen", FAULTS_SCHEMA, "--ddl"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert out.stdout == b"""create table default (
+    "id" varchar not null,
+    "age" bigint,
+    "name" varchar not null,
+    "born" varchar not null,
+    "greeting" varchar
+)
+"""
+
+
+def test_generator_faults_invalid():
+    schema = '[{"field": "id", "source": "uuid", "nullRate": 0.6, "emptyRate": 0.6}]'
+    out = subprocess.run(["./dct", "gen", schema], capture_output=True)
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: expected the rates of field id to add up to at most 1: 1.2\n"
+    )
+
+    schema = '[{"field": "id", "source": "uuid", "corruptRate": 0.1, "corruptions": ["typos"]}]'
+    out = subprocess.run(["./dct", "gen", schema], capture_output=True)
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: unsupported corruption `typos` for field id, expected one of [whitespace wrongType outOfRange malformedDate confusables]\n"
+    )
+
+
+def test_generator_sequences():
+    schema = """[
+    {"field": "id", "source": "sequence", "config": {"start": 100, "step": 5}},
+    {"field": "n", "source": "sequence"},
+    {"field": "at", "source": "monotonicDatetime", "config": {"start": "2024-01-01 00:00:00", "tz": "Europe/Paris", "jitter": "10m"}}
+]"""
+    out = subprocess.run(
+        ["./dct", "gen", schema, "-n", "500", "-f", "ndjson"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = [json.loads(line) for line in out.stdout.splitlines()]
+    assert [r["id"] for r in rows] == list(range(100, 2600, 5))
+    assert [r["n"] for r in rows] == list(range(500))
+    assert rows[0]["at"] == "2024-01-01T00:00:00+01:00"
+    at = [datetime.datetime.fromisoformat(r["at"]) for r in rows]
+    assert all(
+        datetime.timedelta(seconds=1) <= b - a <= datetime.timedelta(minutes=10)
+        for a, b in zip(at, at[1:])
+    )
+
+
+def test_generator_unique():
+    schema = """[
+    {"field": "code", "source": "randomUniformInt", "config": {"min": 0, "max": 200}, "unique": true},
+    {"field": "tag", "source": "randomAscii", "config": {"length": 2}, "unique": true, "nullRate": 0.2}
+]"""
+    out = subprocess.run(
+        ["./dct", "gen", schema, "-n", "200", "-f", "ndjson", "--seed", "4"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = [json.loads(line) for line in out.stdout.splitlines()]
+    assert sorted(r["code"] for r in rows) == list(range(200))
+    tags = [r["tag"] for r in rows if r["tag"] is not None]
+    assert len(tags) == len(set(tags))
+    assert len(tags) < len(rows)
+
+
+def test_generator_unique_exhausted():
+    schema = '[{"field": "code", "source": "randomUniformInt", "config": {"min": 0, "max": 10}, "unique": true}]'
+    out = subprocess.run(["./dct", "gen", schema, "-n", "11"], capture_output=True)
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: failed to generate a unique value for field code in 1000 tries, it may have run out after 10 values\n"
+    )
+
+
+def test_generator_weighted_enum():
+    schema = """[
+    {"field": "status", "source": "randomEnum", "config": {"file": "test/resources/order_statuses.csv"}},
+    {"field": "category", "source": "randomEnum", "config": {"values": ["a", "b", "c", "d"], "distribution": "zipf", "s": 2}},
+    {"field": "side", "source": "randomEnum", "config": {"values": ["buy", "sell", "never"], "weights": [0.5, 0.5, 0]}}
+]"""
+    out = subprocess.run(
+        ["./dct", "gen", schema, "-n", "10000", "-f", "ndjson", "--seed", "1"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = [json.loads(line) for line in out.stdout.splitlines()]
+
+    def share(field, value):
+        return sum(r[field] == value for r in rows) / len(rows)
+
+    assert 0.88 < share("status", "paid") < 0.92
+    assert 0.005 < share("status", "disputed") < 0.015
+    # 1 / (1 + 1/4 + 1/9 + 1/16)
+    assert 0.68 < share("category", "a") < 0.72
+    assert share("category", "b") > share("category", "c") > share("category", "d")
+    assert share("side", "never") == 0
+
+
+def test_generator_weighted_enum_invalid():
+    schema = '[{"field": "status", "source": "randomEnum", "config": {"values": ["paid", "refunded"], "weights": [1]}}]'
+    out = subprocess.run(["./dct", "gen", schema], capture_output=True)
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: expected a weight for each of the 2 values of field status, got 1\n"
+    )
+
+
+def test_generator_distributions():
+    schema = r"""[
+    {"field": "height", "source": "randomNormal", "config": {"mean": 175, "std": 10, "digits": 4}},
+    {"field": "score", "source": "randomUniformFloat", "config": {"min": 1, "max": 2, "decimals": 2}},
+    {"field": "income", "source": "randomLognormal", "config": {"mu": 10, "sigma": 0.5}},
+    {"field": "wait", "source": "randomExponential", "config": {"rate": 0.5}},
+    {"field": "share", "source": "randomBeta", "config": {"alpha": 2, "beta": 5}},
+    {"field": "price", "source": "randomDecimal", "config": {"precision": 6, "scale": 2, "min": 0, "max": 100}},
+    {"field": "sku", "source": "randomRegex", "config": {"pattern": "^[A-Z]{2}-\\d{3,5}(-[a-f0-9]+)?$"}},
+    {"field": "note", "source": "randomWords", "config": {"min": 3, "max": 6, "sentence": true}},
+    {"field": "color", "source": "randomFromFile", "config": {"file": "test/resources/colors.txt"}}
+]"""
+    out = subprocess.run(
+        ["./dct", "gen", schema, "-n", "5000", "-f", "ndjson", "--seed", "1"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = [json.loads(line) for line in out.stdout.splitlines()]
+
+    def mean(field):
+        return sum(r[field] for r in rows) / len(rows)
+
+    assert all(len(str(r["height"]).replace(".", "").lstrip("0")) <= 4 for r in rows)
+    assert all(1 <= r["score"] <= 2 and round(r["score"], 2) == r["score"] for r in rows)
+    # e^(mu + sigma^2 / 2)
+    assert 23000 < mean("income") < 26000
+    assert 1.9 < mean("wait") < 2.1
+    assert all(0 < r["share"] < 1 for r in rows) and 0.27 < mean("share") < 0.3
+    assert all(0 <= r["price"] <= 100 and round(r["price"], 2) == r["price"] for r in rows)
+    # decimals keep their scale
+    assert all(re.fullmatch(rb"\d+\.\d\d", p) for p in re.findall(rb'"price":([^,]+),', out.stdout))
+    assert all(re.fullmatch(r"[A-Z]{2}-\d{3,5}(-[a-f0-9]+)?", r["sku"]) for r in rows)
+    assert all(re.fullmatch(r"[A-Z][a-z]*( [a-z]+){2,5}\.", r["note"]) for r in rows)
+    assert {r["color"] for r in rows} == {"red", "green", "blue"}
+
+
+def test_generator_decimal_ddl():
+    schema = '[{"field": "price", "source": "randomDecimal", "config": {"precision": 6, "scale": 2}}]'
+    out = subprocess.run(["./dct", "gen", schema, "--ddl", "-t", "prices"], capture_output=True)
+
+    assert out.stderr == b""
+    assert out.stdout == b'create table prices (\n    "price" decimal(6,2) not null\n)\n'
+
+
+def helper_read_csv(path):
+    with open(path, newline="") as f:
+        return list(csv.DictReader(f))
+
+
+def test_generator_tables():
+    out = subprocess.run(
+        [
+            "./dct",
+            "gen",
+            "test/resources/generator-tables.json",
+            "-o",
+            "tmp_test_generator_tables",
+            "--seed",
+            "1",
+        ],
+        capture_output=True,
+    )
+    customers = helper_read_csv("tmp_test_generator_tables/customers.csv")
+    orders = helper_read_csv("tmp_test_generator_tables/orders.csv")
+    items = helper_read_csv("tmp_test_generator_tables/order_items.csv")
+    shutil.rmtree("tmp_test_generator_tables")
+
+    assert out.stderr == b""
+    assert len(customers) == 10
+    assert {o["customer_id"] for o in orders} <= {c["id"] for c in customers}
+    assert {i["order_id"] for i in items} == {o["id"] for o in orders}
+    assert all(1 <= [i["order_id"] for i in items].count(o["id"]) <= 4 for o in orders)
+
+
+def test_generator_tables_uniform_ref():
+    schema = """{"tables": [
+    {"table": "reviews", "lines": 50, "fields": [
+        {"field": "product", "source": "ref", "config": {"table": "products", "field": "name"}},
+        {"field": "stars", "source": "randomUniformInt", "config": {"min": 1, "max": 6}}
+    ]},
+    {"table": "products", "lines": 5, "fields": [{"field": "name", "source": "companies", "nullRate": 0.4}]}
+]}"""
+    out = subprocess.run(
+        ["./dct", "gen", schema, "-f", "ndjson", "-o", "tmp_test_generator_tables", "--seed", "3"],
+        capture_output=True,
+    )
+    products = [json.loads(line) for line in open("tmp_test_generator_tables/products.ndjson")]
+    reviews = [json.loads(line) for line in open("tmp_test_generator_tables/reviews.ndjson")]
+    shutil.rmtree("tmp_test_generator_tables")
+
+    assert out.stderr == b""
+    assert len(products) == 5
+    assert len(reviews) == 50
+    assert {r["product"] for r in reviews} <= {p["name"] for p in products if p["name"]}
+
+
+def test_generator_tables_ddl():
+    out = subprocess.run(
+        ["./dct", "gen", "test/resources/generator-tables.json", "--ddl"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert out.stdout == open("./test/expected/test_generator_tables_ddl.sql", mode="rb").read()
+
+
+def test_generator_tables_ddl_references():
+    schema = """{"tables": [
+        {"table": "people", "fields": [
+            {"field": "id", "source": "sequence"},
+            {"field": "name", "source": "firstNames"}
+        ]},
+        {"table": "pets", "fields": [
+            {"field": "owner_id", "source": "ref", "config": {"table": "people", "field": "id"}},
+            {"field": "owner_name", "source": "ref", "config": {"table": "people", "field": "name"}}
+        ]}
+    ]}"""
+    out = subprocess.run(["./dct", "gen", schema, "--ddl"], capture_output=True)
+
+    # names may repeat so they can't be referenced by a foreign key
+    ddl = str(out.stdout, "utf-8")
+    assert out.stderr == b""
+    assert '"id" bigint not null unique,' in ddl
+    assert '"owner_id" bigint not null references people("id"),' in ddl
+    assert '"owner_name" varchar not null\n);' in ddl
+    assert '"name" varchar not null\n);' in ddl
+
+
+def test_generator_tables_invalid():
+    cases = {
+        '{"tables": [{"table": "a", "fields": [{"field": "b_id", "source": "ref", "config": {"table": "b", "field": "id"}}]}, '
+        '{"table": "b", "fields": [{"field": "id", "source": "ref", "config": {"table": "a", "field": "b_id"}}]}]}':
+        "Error: tables reference each other in a cycle: a, b\n",
+        '{"tables": [{"table": "a", "fields": [{"field": "b_id", "source": "ref", "config": {"table": "b", "field": "id"}}]}]}':
+        "Error: unknown table `b` referenced by a.b_id\n",
+        '{"tables": [{"table": "a", "fields": [{"field": "id", "source": "uuid"}]}, '
+        '{"table": "b", "fields": [{"field": "a_id", "source": "ref", "config": {"table": "a", "field": "id", "cardinality": {"distribution": "zipf"}}}]}]}':
+        "Error: unsupported cardinality `zipf` for b.a_id, expected one of [uniform poisson]\n",
+        '[{"field": "a_id", "source": "ref", "config": {"table": "a", "field": "id"}}]':
+        "Error: ref fields reference other tables, expected a schema of tables\n",
+        '{"tables": [{"table": "a", "fields": [{"field": "id", "source": "uuid"}]}]}':
+        "Error: a schema of tables is written to a file per table, expected --outfile directory\n",
+    }
+    for schema, expected in cases.items():
+        out = subprocess.run(["./dct", "gen", schema], capture_output=True)
+
+        assert out.returncode != 0
+        assert str(out.stderr, "utf-8").endswith(expected)
+
+
+WORKERS_SCHEMA = """[
+    {"field": "n", "source": "sequence", "config": {"start": 1}},
+    {"field": "name", "source": "firstNames"},
+    {"field": "born", "source": "randomDate", "config": {"min": "2000-01-01", "max": "2001-01-01"}},
+    {"field": "greeting", "source": "derived", "config": {"fields": ["name"], "expression": "'hi ' + name"}}
+]"""
+
+
+def test_generator_workers():
+    runs = [
+        subprocess.run(
+            ["./dct", "gen", WORKERS_SCHEMA, "-n", "25000", "-f", "ndjson", "--seed", "9", "--workers", workers],
+            capture_output=True,
+        )
+        for workers in ["1", "4"]
+    ]
+
+    assert runs[0].stderr == b""
+    assert runs[0].stdout == runs[1].stdout
+    rows = [json.loads(line) for line in runs[0].stdout.splitlines()]
+    assert [r["n"] for r in rows] == list(range(1, 25001))
+    assert all(r["greeting"] == "hi " + r["name"] for r in rows)
+
+
+def test_generator_rows_per_file():
+    os.makedirs("tmp_test_generator_parts", exist_ok=True)
+    out = subprocess.run(
+        [
+            "./dct",
+            "gen",
+            WORKERS_SCHEMA,
+            "-n",
+            "25",
+            "--seed",
+            "9",
+            "--rows-per-file",
+            "10",
+            "-o",
+            "tmp_test_generator_parts/people.csv",
+        ],
+        capture_output=True,
+    )
+    parts = sorted(os.listdir("tmp_test_generator_parts"))
+    rows = [helper_read_csv(f"tmp_test_generator_parts/{part}") for part in parts]
+    shutil.rmtree("tmp_test_generator_parts")
+    whole = subprocess.run(
+        ["./dct", "gen", WORKERS_SCHEMA, "-n", "25", "--seed", "9"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert parts == ["people-00000.csv", "people-00001.csv", "people-00002.csv"]
+    assert [len(r) for r in rows] == [10, 10, 5]
+    assert [r for part in rows for r in part] == list(csv.DictReader(io.StringIO(str(whole.stdout, "utf-8"))))
+
+
+def test_generator_rows_per_file_invalid():
+    cases = {
+        ("--rows-per-file", "10"): "Error: files of --rows-per-file are named after --outfile, expected --outfile\n",
+        ("--rows-per-file", "-1"): "Error: expected --rows-per-file to be at least 1\n",
+        ("--workers", "-1"): "Error: expected --workers to be at least 1\n",
+    }
+    for flags, expected in cases.items():
+        out = subprocess.run(["./dct", "gen", WORKERS_SCHEMA, *flags], capture_output=True)
+
+        assert out.returncode != 0
+        assert str(out.stderr, "utf-8").endswith(expected)
+
+
+def test_generator_validate():
+    for schema in ["test/resources/generator-schema.json", "test/resources/generator-tables.json"]:
+        out = subprocess.run(["./dct", "gen", "--validate", schema], capture_output=True)
+
+        assert out.stderr == b""
+        assert out.stdout == b"schema is valid\n"
+
+
+INVALID_SCHEMA = """[
+    {"field": "id", "source": "uuidd"},
+    {"field": "n", "source": "randomUniformInt", "config": {"min": "1", "max": 10}},
+    {"field": "x", "source": "randomNormal", "config": {"mean": 1, "sdt": 2}},
+    {"source": "uuid"},
+    {"field": "name", "source": "firstNames", "nulRate": 0.1}
+]"""
+
+
+def test_generator_validate_invalid():
+    cases = {
+        INVALID_SCHEMA: [
+            "unsupported source `uuidd` for field id, expected one of [",
+            "invalid config of field n: expected an integer for `config.min`, not a string",
+            "invalid config of field x: unknown key `sdt`",
+            'expected a name in field 4, e.g. {"field": "id", "source": "uuid"}',
+            "invalid field name: unknown key `nulRate`",
+        ],
+        """[
+            {"field": "age", "source": "randomUniformInt", "config": {"min": 50, "max": 10}},
+            {"field": "at", "source": "randomDatetime", "config": {"tz": "Mars/Base"}},
+            {"field": "day", "source": "randomDate", "config": {"min": "2020-01-01", "max": "2019-01-01"}},
+            {"field": "code", "source": "randomRegex"},
+            {"field": "bad", "source": "derived", "config": {"fields": ["age"], "expression": "age +"}}
+        ]""": [
+            "invalid min and max for field age, expected min below max as max is excluded, not 50 and 10",
+            "failed to parse tz of field at: unknown time zone Mars/Base",
+            "invalid min and max for field day, expected min before max",
+            "missing `pattern` in the config of field code, randomRegex expects `pattern`",
+            "failed to compile expression `age +` for field bad",
+        ],
+        """[
+            {"field": "a", "source": "derived", "config": {"fields": ["b"], "expression": "b"}},
+            {"field": "b", "source": "derived", "config": {"fields": ["a"], "expression": "a"}}
+        ]""": ["derived fields read each other in a cycle: a, b"],
+        """[{"field": "a", "source": "derived", "config": {"fields": ["missing"], "expression": "missing"}}]""": [
+            "unknown field `missing` read by derived field a"
+        ],
+        """[
+            {"field": "name", "source": "randomAscii"},
+            {"field": "n", "source": "randomUniformInt"},
+            {"field": "x", "source": "randomNormal", "config": {"mean": 1}}
+        ]""": [
+            "missing `length` in the config of field name, randomAscii expects `length`",
+            "missing `min`, `max` in the config of field n, randomUniformInt expects `min`, `max`",
+            "missing `std` in the config of field x, randomNormal expects `mean`, `std`",
+        ],
+        """[
+            {"field": "id", "source": "uuidd"},
+            {"field": "age", "source": "randomUniformInt", "config": {"min": 50, "max": 10}},
+            {"field": "at", "source": "randomDatetime", "config": {"tz": "Mars/Base"}},
+            {"field": "a", "source": "derived", "config": {"fields": ["b"], "expression": "b"}},
+            {"field": "b", "source": "derived", "config": {"fields": ["a"], "expression": "a"}}
+        ]""": [
+            "unsupported source `uuidd` for field id, expected one of [",
+            "invalid min and max for field age, expected min below max as max is excluded, not 50 and 10",
+            "failed to parse tz of field at: unknown time zone Mars/Base",
+            "derived fields read each other in a cycle: a, b",
+        ],
+    }
+    for schema, expected in cases.items():
+        checked = subprocess.run(["./dct", "gen", "--validate", schema], capture_output=True)
+        generated = subprocess.run(["./dct", "gen", schema], capture_output=True)
+
+        assert checked.returncode != 0
+        assert generated.returncode != 0
+        assert checked.stdout == b""
+        assert generated.stdout == b""
+        for message in expected:
+            assert message in str(checked.stderr, "utf-8")
+            assert message in str(generated.stderr, "utf-8")
+
+
+def test_generator_csv_quoting():
+    out = subprocess.run(
+        [
+            "./dct",
+            "gen",
+            """[
+                {"field": "comma", "source": "derived", "config": {"expression": "'a, b'"}},
+                {"field": "quote", "source": "derived", "config": {"expression": "'say \\"hi\\"'"}},
+                {"field": "lines", "source": "derived", "config": {"expression": "'one\\\\ntwo\\\\rthree'"}}
+            ]""",
+            "-n",
+            "2",
+        ],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = list(csv.reader(io.StringIO(str(out.stdout, "utf-8"), newline="")))
+    assert rows == [["comma", "quote", "lines"]] + [["a, b", 'say "hi"', "one\ntwo\rthree"]] * 2
+
+
+def test_generator_derived_order():
+    out = subprocess.run(
+        [
+            "./dct",
+            "gen",
+            """[
+                {"field": "greeting", "source": "derived", "config": {"fields": ["name"], "expression": "'hi ' + name"}},
+                {"field": "name", "source": "firstNames"}
+            ]""",
+            "-n",
+            "5",
+            "--seed",
+            "1",
+        ],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    rows = list(csv.DictReader(io.StringIO(str(out.stdout, "utf-8"))))
+    assert len(rows) == 5
+    assert all(r["greeting"] == "hi " + r["name"] for r in rows)
+
+
+def test_generator_json_schema():
+    json_schema = json.load(open("./examples/generator.schema.json"))
+    out = subprocess.run(
+        ["./dct", "gen", '[{"field": "a", "source": "none"}]'], capture_output=True
+    )
+
+    sources = re.search(r"expected one of \[(.*)\]", str(out.stderr, "utf-8")).group(1)
+    assert json_schema["$defs"]["field"]["properties"]["source"]["enum"] == sources.split(" ")
+
+
+# the subset of json schema used by examples/generator.schema.json, a keyword
+# it doesn't know fails so nothing in the schema is silently skipped
+def helper_json_schema_errors(value, schema, root, at="$"):
+    if "$ref" in schema:
+        ref = root
+        for part in schema["$ref"].removeprefix("#/").split("/"):
+            ref = ref[part]
+        return helper_json_schema_errors(value, ref, root, at)
+
+    types = {
+        "object": lambda v: isinstance(v, dict),
+        "array": lambda v: isinstance(v, list),
+        "string": lambda v: isinstance(v, str),
+        "boolean": lambda v: isinstance(v, bool),
+        "integer": lambda v: isinstance(v, int) and not isinstance(v, bool),
+        "number": lambda v: isinstance(v, (int, float)) and not isinstance(v, bool),
+    }
+    number = types["number"](value)
+    errors = []
+    for keyword, expected in schema.items():
+        if keyword in ["$schema", "$defs", "title", "description"]:
+            continue
+        elif keyword == "type":
+            if not types[expected](value):
+                errors.append(f"{at}: expected {expected}")
+        elif keyword == "enum":
+            if value not in expected:
+                errors.append(f"{at}: expected one of {expected}")
+        elif keyword == "const":
+            if value != expected:
+                errors.append(f"{at}: expected {expected}")
+        elif keyword == "minimum":
+            if number and value < expected:
+                errors.append(f"{at}: expected at least {expected}")
+        elif keyword == "maximum":
+            if number and value > expected:
+                errors.append(f"{at}: expected at most {expected}")
+        elif keyword == "exclusiveMinimum":
+            if number and value <= expected:
+                errors.append(f"{at}: expected above {expected}")
+        elif keyword == "minLength":
+            if isinstance(value, str) and len(value) < expected:
+                errors.append(f"{at}: expected at least {expected} characters")
+        elif keyword == "pattern":
+            if isinstance(value, str) and not re.search(expected, value):
+                errors.append(f"{at}: expected to match {expected}")
+        elif keyword == "minItems":
+            if isinstance(value, list) and len(value) < expected:
+                errors.append(f"{at}: expected at least {expected} items")
+        elif keyword == "items":
+            if isinstance(value, list):
+                for i, item in enumerate(value):
+                    errors += helper_json_schema_errors(item, expected, root, f"{at}[{i}]")
+        elif keyword == "maxProperties":
+            if isinstance(value, dict) and len(value) > expected:
+                errors.append(f"{at}: expected at most {expected} keys")
+        elif keyword == "required":
+            if isinstance(value, dict):
+                errors += [f"{at}: missing `{key}`" for key in expected if key not in value]
+        elif keyword == "properties":
+            if isinstance(value, dict):
+                for key, sub in expected.items():
+                    if key in value:
+                        errors += helper_json_schema_errors(value[key], sub, root, f"{at}.{key}")
+        elif keyword == "additionalProperties":
+            assert expected is False
+            if isinstance(value, dict):
+                known = schema.get("properties", {})
+                errors += [f"{at}: unknown key `{key}`" for key in value if key not in known]
+        elif keyword == "allOf":
+            for sub in expected:
+                errors += helper_json_schema_errors(value, sub, root, at)
+        elif keyword == "oneOf":
+            valid = [sub for sub in expected if not helper_json_schema_errors(value, sub, root, at)]
+            if len(valid) != 1:
+                errors.append(f"{at}: expected exactly one of oneOf to match, not {len(valid)}")
+        elif keyword == "if":
+            if not helper_json_schema_errors(value, expected, root, at):
+                errors += helper_json_schema_errors(value, schema.get("then", {}), root, at)
+        elif keyword == "then":
+            continue
+        else:
+            raise ValueError(f"unsupported json schema keyword {keyword}")
+    return errors
+
+
+@pytest.mark.parametrize(
+    "schema",
+    sorted(glob.glob("test/resources/generator-*.json") + glob.glob("examples/generator-*.json")),
+)
+def test_generator_json_schema_files(schema: str):
+    json_schema = json.load(open("./examples/generator.schema.json"))
+    out = subprocess.run(["./dct", "gen", "--validate", schema], capture_output=True)
+
+    # generator-config.json sets every key of every source, so the json
+    # schema and the parser agree on each of them
+    assert out.stderr == b""
+    assert out.stdout == b"schema is valid\n"
+    assert helper_json_schema_errors(json.load(open(schema)), json_schema, json_schema) == []
+
+
+def test_generator_json_schema_unknown_key():
+    json_schema = json.load(open("./examples/generator.schema.json"))
+    schema = [{"field": "a", "source": "randomAscii", "config": {"length": 1, "size": 2}}]
+
+    assert helper_json_schema_errors(schema, json_schema, json_schema) == [
+        "$: expected exactly one of oneOf to match, not 0"
+    ]
+    assert helper_json_schema_errors(schema, json_schema["$defs"]["fields"], json_schema) == [
+        "$[0].config: unknown key `size`"
+    ]
+
+
 def test_flattify_ndjson():
     out = subprocess.run(
         [
@@ -476,9 +1630,401 @@ def test_prof(filetype: str):
         capture_output=True,
     )
 
-    # output is not ordered!
     assert out.stderr == b""
-    assert out.stdout != b""
+    assert (
+        out.stdout
+        == open(f"./test/expected/test_prof_{filetype}.txt", mode="rb").read()
+    )
+
+
+def test_prof_top():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/left.csv", "--top", "1"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert out.stdout == open("./test/expected/test_prof_top.txt", mode="rb").read()
+
+
+def test_prof_shapes():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/contacts.csv"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert (
+        out.stdout == open("./test/expected/test_prof_shapes.txt", mode="rb").read()
+    )
+
+
+def test_prof_shapes_json():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/contacts.csv", "-f", "json"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    columns = {c["name"]: c for c in json.loads(out.stdout)["columns"]}
+    assert columns["email"]["semantic_type"] == "email"
+    assert columns["phone"]["semantic_type"] == "phone"
+    # digits alone are ids and decimals numbers rather than phone numbers
+    assert "semantic_types" not in columns["account"]
+    assert columns["balance"]["type"] == "VARCHAR"
+    assert "semantic_types" not in columns["balance"]
+    assert "semantic_type" not in columns["code"]
+    assert columns["code"]["shapes"][0] == {
+        "shape": "AAA-9999",
+        "count": 8,
+        "examples": ["ABC-1234", "ABC-1235", "ABD-2345"],
+    }
+
+
+def test_prof_hygiene():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/dirty.csv"],
+        capture_output=True,
+    )
+
+    # duckdb can't read the file but the findings are written first
+    assert out.returncode != 0
+    assert b"Invalid unicode" in out.stderr
+    assert (
+        out.stdout == open("./test/expected/test_prof_hygiene.txt", mode="rb").read()
+    )
+
+
+def test_prof_hygiene_delimiter():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/semicolon.csv", "-f", "json"],
+        capture_output=True,
+    )
+
+    # fields are split on the delimiter duckdb detects, quoted commas included
+    hygiene = json.loads(out.stdout)["hygiene"]
+    assert hygiene["field_counts"] == {"2": 1, "3": 5}
+    assert hygiene["issues"] == {"ragged_row": 1}
+    assert hygiene["findings"] == [
+        {
+            "issue": "ragged_row",
+            "line": 4,
+            "offset": 45,
+            "detail": "2 fields, expected 3 from the header",
+        }
+    ]
+
+
+def test_prof_json():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/left.csv", "-f", "json"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    report = json.loads(out.stdout)
+    assert report["rows"] == 11
+    assert [c["name"] for c in report["columns"]] == ["a", "b", "c"]
+    assert report["columns"][0]["top_values"][0] == {"value": "1", "count": 6}
+    assert sum(b["count"] for b in report["columns"][0]["histogram"]) == 11
+    assert report["columns"][2]["lengths"]["max"] == 3
+    assert report["hygiene"]["line_endings"] == {"LF": 12}
+    assert report["hygiene"]["findings"] == []
+
+
+def test_prof_html():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/left.csv", "-f", "html"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert out.stdout.startswith(b"<!DOCTYPE html>")
+    assert b"<h2>a <small>BIGINT</small></h2>" in out.stdout
+
+
+def test_prof_html_blanks():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/blanks.ndjson", "-f", "html"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert b"<tr><th>Empty Count</th><td>1</td></tr>" in out.stdout
+    assert b"<tr><th>Whitespace Only Count</th><td>1</td></tr>" in out.stdout
+
+
+def test_prof_format_invalid():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/left.csv", "-f", "xml"],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: unsupported format: xml, expected one of [text json html]\n"
+    )
+
+
+def test_prof_relationships():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/order_lines.csv", "-r"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert (
+        out.stdout
+        == open("./test/expected/test_prof_relationships.txt", mode="rb").read()
+    )
+
+
+def test_prof_relationships_json():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/order_lines.csv", "-r", "-f", "json"],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    report = json.loads(out.stdout)
+    assert report["rows"] == 82
+    assert report["candidate_keys"] == [["order_id", "line_no"]]
+    assert {"from": "sku", "to": "price"} in report["functional_dependencies"]
+    assert {"from": "order_id", "to": "city"} in report["functional_dependencies"]
+    assert report["correlations"][0]["left"] == "price"
+    assert report["correlations"][0]["right"] == "total"
+
+
+def test_prof_relationships_sample_key():
+    # more rows than the sample, the last repeats an id
+    with open("./tmp_test_prof_versions.csv", "w") as f:
+        f.write("id,version\n")
+        for i in range(11999):
+            f.write(f"{i},1\n")
+        f.write("11998,2\n")
+
+    out = subprocess.run(
+        ["./dct", "prof", "-r", "./tmp_test_prof_versions.csv", "-f", "json"],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_prof_versions.csv")
+
+    # id is unique in the sample but repeated in the last row, so the key
+    # containing it is still searched
+    assert out.stderr == b""
+    relationships = json.loads(out.stdout)
+    assert relationships["rows"] == 12000
+    assert relationships["sample_rows"] == 10000
+    assert relationships["candidate_keys"] == [["id", "version"]]
+
+
+def test_prof_relationships_html():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/order_lines.csv", "-r", "-f", "html"],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: --relationships supports text and json formats, not html\n"
+    )
+
+
+def helper_prof_save_baseline(*args: str):
+    subprocess.run(
+        [
+            "./dct",
+            "prof",
+            "./test/resources/feed_baseline.csv",
+            "--save",
+            "tmp_test_prof_baseline.json",
+            "-o",
+            os.devnull,
+            *args,
+        ],
+    )
+
+
+def test_prof_drift():
+    helper_prof_save_baseline()
+    out = subprocess.run(
+        [
+            "./dct",
+            "prof",
+            "./test/resources/feed_drifted.csv",
+            "--against",
+            "tmp_test_prof_baseline.json",
+        ],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_prof_baseline.json")
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "2 columns drifted from tmp_test_prof_baseline.json\n"
+    )
+    assert out.stdout == open("./test/expected/test_prof_drift.txt", mode="rb").read()
+
+
+def test_prof_drift_none():
+    helper_prof_save_baseline()
+    out = subprocess.run(
+        [
+            "./dct",
+            "prof",
+            "./test/resources/feed_baseline.csv",
+            "--against",
+            "tmp_test_prof_baseline.json",
+            "-f",
+            "json",
+        ],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_prof_baseline.json")
+
+    assert out.returncode == 0
+    drift = json.loads(out.stdout)
+    assert drift["drifted"] == []
+    assert all(m["value"] == 0 for m in drift["metrics"])
+
+
+def test_prof_drift_json():
+    helper_prof_save_baseline()
+    out = subprocess.run(
+        [
+            "./dct",
+            "prof",
+            "./test/resources/feed_drifted.csv",
+            "--against",
+            "tmp_test_prof_baseline.json",
+            "-f",
+            "json",
+        ],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_prof_baseline.json")
+
+    assert out.returncode != 0
+    drift = json.loads(out.stdout)
+    assert drift["drifted"] == ["amount", "channel"]
+    metrics = {(m["column"], m["metric"]): m for m in drift["metrics"]}
+    assert metrics[("channel", "new_categories")]["current"] == "app"
+    assert metrics[("amount", "psi")]["drifted"]
+    assert not metrics[("day", "ks")]["drifted"]
+
+
+def test_prof_drift_new_categories():
+    # the baseline lists one top value but saves every category
+    helper_prof_save_baseline("-t", "1")
+    out = subprocess.run(
+        [
+            "./dct",
+            "prof",
+            "./test/resources/feed_drifted.csv",
+            "--against",
+            "tmp_test_prof_baseline.json",
+            "-f",
+            "json",
+        ],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_prof_baseline.json")
+
+    drift = json.loads(out.stdout)
+    metrics = {(m["column"], m["metric"]): m for m in drift["metrics"]}
+    assert metrics[("channel", "new_categories")]["baseline"] == "3 values"
+    assert metrics[("channel", "new_categories")]["current"] == "app"
+
+
+def test_prof_selection():
+    out = subprocess.run(
+        [
+            "./dct",
+            "prof",
+            "./test/resources/orders.csv",
+            "--columns",
+            "status,amount",
+            "--where",
+            "amount > 0",
+        ],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    assert (
+        out.stdout == open("./test/expected/test_prof_selection.txt", mode="rb").read()
+    )
+
+
+def test_prof_selection_json():
+    out = subprocess.run(
+        [
+            "./dct",
+            "prof",
+            "./test/resources/feed_baseline.csv",
+            "-x",
+            "id,day",
+            "-s",
+            "50",
+            "-f",
+            "json",
+        ],
+        capture_output=True,
+    )
+
+    assert out.stderr == b""
+    report = json.loads(out.stdout)
+    assert report["rows"] == 50
+    assert report["selection"] == {"exclude": ["id", "day"], "sample": "50"}
+    # a sample profiles part of the file so the raw file isn't scanned
+    assert "hygiene" not in report
+    assert [c["name"] for c in report["columns"]] == ["amount", "channel"]
+
+
+def test_prof_selection_sample_consistent():
+    with open("./tmp_test_prof_sample.csv", "w") as f:
+        f.write("id,channel\n")
+        for i in range(20000):
+            f.write(f"{i},{['web', 'store', 'phone'][i % 3]}\n")
+
+    out = subprocess.run(
+        ["./dct", "prof", "./tmp_test_prof_sample.csv", "-s", "1000", "-f", "json"],
+        capture_output=True,
+    )
+    os.remove("./tmp_test_prof_sample.csv")
+
+    # every section profiles the one sample drawn for the report
+    assert out.stderr == b""
+    report = json.loads(out.stdout)
+    assert report["rows"] == 1000
+    ids, channels = report["columns"]
+    assert ids["count"] == 1000
+    assert sum(bucket["count"] for bucket in ids["histogram"]) == 1000
+    assert sum(value["count"] for value in channels["top_values"]) == 1000
+
+
+def test_prof_selection_invalid_column():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/orders.csv", "-c", "total"],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: unknown column `total`, expected one of [order_id customer_id status email amount]\n"
+    )
+
+
+def test_prof_selection_invalid_sample():
+    out = subprocess.run(
+        ["./dct", "prof", "./test/resources/orders.csv", "-s", "150%"],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "Error: expected --sample percentage between 0 and 100: 150%\n"
+    )
 
 
 def test_js2sql_simple():
@@ -613,3 +2159,92 @@ def test_infer(file: str):
     )
 
     assert out.stdout == open("./test/expected/left_schema.sql", mode="rb").read()
+
+
+def test_check():
+    out = subprocess.run(
+        [
+            "./dct",
+            "check",
+            "./test/resources/rules.yaml",
+            "./test/resources/orders.csv",
+        ],
+        capture_output=True,
+    )
+
+    assert out.returncode == 1
+    assert str(out.stderr, "utf-8").endswith("6 of 8 checks failed\n")
+    assert out.stdout == open("./test/expected/test_check.txt", mode="rb").read()
+
+
+def test_check_output():
+    out = subprocess.run(
+        [
+            "./dct",
+            "check",
+            "./test/resources/rules.yaml",
+            "./test/resources/orders.csv",
+            "-o",
+            "./tmp_test_check_output.csv",
+        ],
+        capture_output=True,
+    )
+
+    # the summary is written to --output and the failing rows next to it
+    assert out.returncode == 1
+    for view in ["", "-failures"]:
+        written = f"./tmp_test_check_output{view}.csv"
+        assert (
+            open(written, mode="rb").read()
+            == open(f"./test/expected/test_check_output{view}.csv", mode="rb").read()
+        )
+        os.remove(written)
+
+
+def test_check_pass():
+    out = subprocess.run(
+        [
+            "./dct",
+            "check",
+            "./test/resources/rules_pass.yaml",
+            "./test/resources/orders.csv",
+        ],
+        capture_output=True,
+    )
+
+    assert out.returncode == 0
+    assert out.stderr == b""
+    assert out.stdout == open("./test/expected/test_check_pass.txt", mode="rb").read()
+
+
+def test_check_same_database():
+    out = subprocess.run(
+        [
+            "./dct",
+            "check",
+            "./test/resources/rules_shop.yaml",
+            "duckdb:///test/resources/shop.duckdb?table=orders",
+        ],
+        capture_output=True,
+    )
+
+    assert out.returncode == 1
+    assert str(out.stderr, "utf-8").endswith("1 of 1 checks failed\n")
+    assert out.stdout == open("./test/expected/test_check_same_database.txt", mode="rb").read()
+
+
+def test_check_invalid_rules():
+    out = subprocess.run(
+        [
+            "./dct",
+            "check",
+            "./test/resources/rules_invalid.yaml",
+            "./test/resources/orders.csv",
+        ],
+        capture_output=True,
+    )
+
+    assert out.returncode != 0
+    assert str(out.stderr, "utf-8").endswith(
+        "field not_nul not found in type check.ColumnRules: ./test/resources/rules_invalid.yaml\n"
+    )